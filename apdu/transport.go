@@ -0,0 +1,12 @@
+package apdu
+
+// Transport exchanges raw APDUs with a card, independent of how the card is connected
+type Transport interface {
+	// Transmit sends a command APDU and returns the response including the status word
+	Transmit(command []byte) ([]byte, error)
+	// BeginTransaction gains exclusive access to the card until EndTransaction is called
+	BeginTransaction() error
+	EndTransaction() error
+	// Reconnect re-establishes the connection, e.g. after the card was reset
+	Reconnect() error
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/MeneDev/yubi-oath-vpn/oath"
+	"github.com/MeneDev/yubi-oath-vpn/yubikey"
+	scardyubi "github.com/MeneDev/yubi-oath-vpn/yubikey/scard"
+	"github.com/ebfe/scard"
+	"github.com/jessevdk/go-flags"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/term"
+)
+
+type credsCommand struct {
+	List   credsListCommand   `command:"list" description:"List the OATH credentials on the YubiKey"`
+	Add    credsAddCommand    `command:"add" description:"Add an OATH credential from an otpauth:// URI"`
+	Delete credsDeleteCommand `command:"delete" description:"Delete an OATH credential"`
+	Rename credsRenameCommand `command:"rename" description:"Rename an OATH credential (requires firmware 5.3 or newer)"`
+}
+
+func addCredsCommand(parser *flags.Parser) error {
+	_, err := parser.AddCommand("creds", "Manage OATH credentials", "Manage the OATH credentials stored on the inserted YubiKey", &credsCommand{})
+	return err
+}
+
+type credsListCommand struct{}
+
+func (c *credsListCommand) Execute(args []string) error {
+	return withYubiKey(func(key yubikey.YubiKey, password string) error {
+		creds, err := key.ListCredentials(password)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tISSUER\tACCOUNT\tTYPE\tALGORITHM\tPERIOD\tTOUCH")
+		for _, cred := range creds {
+			period := "-"
+			if cred.Period > 0 {
+				period = fmt.Sprintf("%ds", cred.Period)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%t\n", cred.Name, cred.Issuer, cred.Account, cred.Type, cred.Algorithm, period, cred.RequiresTouch)
+		}
+		return w.Flush()
+	})
+}
+
+type credsAddCommand struct {
+	Touch bool `long:"touch" description:"Require touching the YubiKey to calculate a code"`
+	Args  struct {
+		Uri string `positional-arg-name:"otpauth-uri" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func (c *credsAddCommand) Execute(args []string) error {
+	credential, err := oath.ParseUri(c.Args.Uri)
+	if err != nil {
+		return err
+	}
+	credential.RequiresTouch = c.Touch
+
+	return withYubiKey(func(key yubikey.YubiKey, password string) error {
+		err := key.PutCredential(password, credential)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Added credential %s\n", credential.Name())
+		return nil
+	})
+}
+
+type credsDeleteCommand struct {
+	Args struct {
+		Name string `positional-arg-name:"name" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func (c *credsDeleteCommand) Execute(args []string) error {
+	return withYubiKey(func(key yubikey.YubiKey, password string) error {
+		err := key.DeleteCredential(password, c.Args.Name)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Deleted credential %s\n", c.Args.Name)
+		return nil
+	})
+}
+
+type credsRenameCommand struct {
+	Args struct {
+		Name    string `positional-arg-name:"name" required:"yes"`
+		NewName string `positional-arg-name:"new-name" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func (c *credsRenameCommand) Execute(args []string) error {
+	if len(c.Args.NewName) > oath.MaxNameLength {
+		return fmt.Errorf("credential name %q is longer than %d bytes", c.Args.NewName, oath.MaxNameLength)
+	}
+
+	return withYubiKey(func(key yubikey.YubiKey, password string) error {
+		err := key.RenameCredential(password, c.Args.Name, c.Args.NewName)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Renamed credential %s to %s\n", c.Args.Name, c.Args.NewName)
+		return nil
+	})
+}
+
+// withYubiKey opens the first YubiKey found and asks for its password on stdin if one is required
+func withYubiKey(f func(key yubikey.YubiKey, password string) error) error {
+	return withFirstYubiKey(func(key yubikey.YubiKey) error {
+		requiresPassword, err := key.RequiresPassword()
+		if err != nil {
+			return err
+		}
+
+		var password string
+		if requiresPassword {
+			password, err = readPassword("YubiKey password: ")
+			if err != nil {
+				return err
+			}
+		}
+
+		return f(key, password)
+	})
+}
+
+// withFirstYubiKey opens the first reader with "yubi" in its name
+func withFirstYubiKey(f func(key yubikey.YubiKey) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	scardCtx, err := scard.EstablishContext()
+	if err != nil {
+		return err
+	}
+	defer scardCtx.Release()
+
+	readers, err := scardCtx.ListReaders()
+	if err != nil {
+		return err
+	}
+
+	var reader string
+	for _, r := range readers {
+		if strings.Contains(strings.ToLower(r), "yubi") {
+			reader = r
+			break
+		}
+	}
+
+	if reader == "" {
+		return errors.New("no YubiKey found")
+	}
+
+	log.Debug().Str("reader", reader).Msg("using reader")
+
+	key, err := scardyubi.YubiKeyNew(ctx, scardCtx, reader)
+	if err != nil {
+		return err
+	}
+
+	return f(key)
+}
+
+// stdinPasswords is shared by all prompts, a reader per prompt would lose the lines buffered by the previous one
+var stdinPasswords = passwordReaderNew(os.Stdin)
+
+// readPassword reads a line from stdin without echoing it when stdin is a terminal
+func readPassword(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	return stdinPasswords.read()
+}
+
+// passwordReader reads passwords from a terminal without echo or line by line from a pipe
+type passwordReader struct {
+	file  *os.File
+	lines *bufio.Reader
+}
+
+func passwordReaderNew(file *os.File) *passwordReader {
+	return &passwordReader{file: file, lines: bufio.NewReader(file)}
+}
+
+func (r *passwordReader) read() (string, error) {
+	fd := int(r.file.Fd())
+	if term.IsTerminal(fd) {
+		password, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(password), err
+	}
+
+	line, err := r.lines.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
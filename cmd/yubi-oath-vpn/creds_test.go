@@ -0,0 +1,31 @@
+package main
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordReader_Pipe(t *testing.T) {
+	r, w, err := os.Pipe()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer r.Close()
+
+	_, err = io.WriteString(w, "old\nnew\r\nnew")
+	assert.NoError(t, err)
+	w.Close()
+
+	passwords := passwordReaderNew(r)
+	for _, expected := range []string{"old", "new", "new"} {
+		password, err := passwords.read()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, password)
+	}
+
+	_, err = passwords.read()
+	assert.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"github.com/MeneDev/yubi-oath-vpn/yubikey"
+	"github.com/rs/zerolog/log"
+)
+
+// KeyFilter restricts which inserted YubiKeys start a connection
+type KeyFilter struct {
+	Serials     []uint32 `long:"serial" description:"Only use the YubiKey with this serial, can be given multiple times"`
+	MinFirmware string   `long:"min-firmware" description:"Only use YubiKeys with this firmware version or newer (e.g. 5.2.3)"`
+	MaxFirmware string   `long:"max-firmware" description:"Only use YubiKeys with this firmware version or older (e.g. 5.7)"`
+	RequireSlot bool     `long:"require-slot" description:"Only use YubiKeys that contain the credential given by --slot, keys protected by a password cannot be checked and are used"`
+}
+
+func (f KeyFilter) active() bool {
+	return len(f.Serials) > 0 || f.MinFirmware != "" || f.MaxFirmware != ""
+}
+
+// validate checks the filter options so a typo does not silently ignore every key
+func (f KeyFilter) validate() error {
+	if f.MinFirmware != "" {
+		if _, err := yubikey.ParseVersion(f.MinFirmware); err != nil {
+			return err
+		}
+	}
+	if f.MaxFirmware != "" {
+		if _, err := yubikey.ParseVersion(f.MaxFirmware); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applicableYubiKey(key yubikey.YubiKey, filter KeyFilter, slotName string) bool {
+	if filter.active() {
+		info, err := key.DeviceInfo()
+		if err != nil {
+			log.Info().Err(err).Msg("ignoring YubiKey, cannot read device info")
+			return false
+		}
+
+		if !filter.matchesDevice(info) {
+			log.Info().Uint32("serial", info.Serial).Str("version", info.Version.String()).Msg("ignoring YubiKey, it does not match the filter")
+			return false
+		}
+	}
+
+	if filter.RequireSlot && slotName != "" {
+		return hasSlot(key, slotName)
+	}
+
+	return true
+}
+
+func (f KeyFilter) matchesDevice(info yubikey.DeviceInfo) bool {
+	if len(f.Serials) > 0 {
+		found := false
+		for _, serial := range f.Serials {
+			if serial == info.Serial {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if f.MinFirmware != "" {
+		min, err := yubikey.ParseVersion(f.MinFirmware)
+		if err != nil || info.Version.Compare(min) < 0 {
+			return false
+		}
+	}
+
+	if f.MaxFirmware != "" {
+		max, err := yubikey.ParseVersion(f.MaxFirmware)
+		if err != nil || info.Version.Compare(max) > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func hasSlot(key yubikey.YubiKey, slotName string) bool {
+	requiresPassword, err := key.RequiresPassword()
+	if err != nil {
+		log.Info().Err(err).Msg("ignoring YubiKey, cannot select the OATH application")
+		return false
+	}
+
+	if requiresPassword {
+		// listing the credentials requires the password, which is only asked for afterwards
+		log.Debug().Str("slot", slotName).Msg("YubiKey is protected by a password, cannot check for the slot")
+		return true
+	}
+
+	creds, err := key.ListCredentials("")
+	if err != nil {
+		log.Info().Err(err).Msg("ignoring YubiKey, cannot list credentials")
+		return false
+	}
+
+	for _, cred := range creds {
+		if cred.Name == slotName {
+			return true
+		}
+	}
+
+	log.Info().Str("slot", slotName).Msg("ignoring YubiKey, it does not contain the slot")
+	return false
+}
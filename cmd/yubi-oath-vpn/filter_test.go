@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/MeneDev/yubi-oath-vpn/oath"
+	"github.com/MeneDev/yubi-oath-vpn/oath/virtual"
+	"github.com/MeneDev/yubi-oath-vpn/yubikey"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplicableYubiKey(t *testing.T) {
+	newKey := func(password string) yubikey.YubiKey {
+		applet := virtual.AppletNew(virtual.Config{
+			Version:  [3]byte{5, 2, 4},
+			Serial:   1234567,
+			Password: password,
+			Credentials: []virtual.Credential{
+				{Name: "vpn@example.com", Type: oath.TOTP, Algorithm: oath.SHA1, Digits: 6, Secret: []byte("12345678901234567890")},
+			},
+		})
+		return yubikey.YubiKeyNew(context.Background(), applet)
+	}
+
+	tests := []struct {
+		name       string
+		filter     KeyFilter
+		slot       string
+		password   string
+		applicable bool
+	}{
+		{name: "no filter", applicable: true},
+		{name: "serial allowed", filter: KeyFilter{Serials: []uint32{1, 1234567}}, applicable: true},
+		{name: "serial not allowed", filter: KeyFilter{Serials: []uint32{1}}, applicable: false},
+		{name: "firmware in range", filter: KeyFilter{MinFirmware: "5.2", MaxFirmware: "5.2.4"}, applicable: true},
+		{name: "firmware too old", filter: KeyFilter{MinFirmware: "5.4.3"}, applicable: false},
+		{name: "firmware too new", filter: KeyFilter{MaxFirmware: "5.1"}, applicable: false},
+		{name: "slot present", filter: KeyFilter{RequireSlot: true}, slot: "vpn@example.com", applicable: true},
+		{name: "slot missing", filter: KeyFilter{RequireSlot: true}, slot: "other@example.com", applicable: false},
+		{name: "slot not checked with password", filter: KeyFilter{RequireSlot: true}, slot: "other@example.com", password: "abc", applicable: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.applicable, applicableYubiKey(newKey(test.password), test.filter, test.slot))
+		})
+	}
+}
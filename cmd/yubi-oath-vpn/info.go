@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/MeneDev/yubi-oath-vpn/yubikey"
+	"github.com/jessevdk/go-flags"
+)
+
+type infoCommand struct{}
+
+func addInfoCommand(parser *flags.Parser) error {
+	_, err := parser.AddCommand("info", "Show device information", "Show serial, firmware version, form factor and capabilities of the inserted YubiKey", &infoCommand{})
+	return err
+}
+
+func (c *infoCommand) Execute(args []string) error {
+	return withFirstYubiKey(func(key yubikey.YubiKey) error {
+		info, err := key.DeviceInfo()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "Serial:\t%d\n", info.Serial)
+		fmt.Fprintf(w, "Firmware:\t%s\n", info.Version)
+		fmt.Fprintf(w, "Form factor:\t%s\n", info.FormFactor)
+		fmt.Fprintf(w, "FIPS:\t%t\n", info.IsFips)
+		fmt.Fprintf(w, "USB enabled:\t%s\n", info.UsbEnabled)
+		fmt.Fprintf(w, "NFC enabled:\t%s\n", info.NfcEnabled)
+		return w.Flush()
+	})
+}
@@ -1,8 +1,14 @@
 package main
 
+import "time"
+
 type Options struct {
-	ConnectionName string `required:"yes" short:"c" long:"connection" description:"The name of the OpenVPN connection without extension'"`
-	SlotName       string `required:"no" short:"s" long:"slot" description:"The name of the YubiKey slot to use (typically of the form user@example.com)"`
-	ShowVersion    bool   `required:"no" short:"v" long:"version" description:"Show version and exit"`
-	Debug          bool   `required:"no" short:"d" long:"debug" description:"Enable debug logging"`
+	ConnectionName  string        `required:"no" short:"c" long:"connection" description:"The name of the OpenVPN connection without extension'"`
+	SlotName        string        `required:"no" short:"s" long:"slot" description:"The name of the YubiKey slot to use (typically of the form user@example.com)"`
+	ShowVersion     bool          `required:"no" short:"v" long:"version" description:"Show version and exit"`
+	Debug           bool          `required:"no" short:"d" long:"debug" description:"Enable debug logging"`
+	MinCodeValidity time.Duration `required:"no" long:"min-code-validity" default:"5s" description:"Wait for the next time step when the code expires sooner"`
+	ClockOffset     time.Duration `required:"no" long:"clock-offset" default:"0s" description:"Added to the system time when calculating codes, compensates a clock that is off"`
+	KeyFilter
+	RemovalOptions
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/MeneDev/yubi-oath-vpn/keyring"
+	"github.com/MeneDev/yubi-oath-vpn/yubikey"
+	"github.com/jessevdk/go-flags"
+)
+
+type passwordCommand struct {
+	Set    passwordSetCommand    `command:"set" description:"Set or change the OATH password"`
+	Clear  passwordClearCommand  `command:"clear" description:"Remove the OATH password"`
+	Forget passwordForgetCommand `command:"forget" description:"Remove the remembered password of the inserted YubiKey from the keyring"`
+}
+
+func addPasswordCommand(parser *flags.Parser) error {
+	_, err := parser.AddCommand("password", "Manage the OATH password", "Set, change or remove the password protecting the OATH credentials of the inserted YubiKey", &passwordCommand{})
+	return err
+}
+
+type passwordSetCommand struct{}
+
+func (c *passwordSetCommand) Execute(args []string) error {
+	return withYubiKey(func(key yubikey.YubiKey, password string) error {
+		newPassword, err := readPassword("New password: ")
+		if err != nil {
+			return err
+		}
+
+		if newPassword == "" {
+			return errors.New("the new password must not be empty, use 'password clear' to remove the password")
+		}
+
+		repeated, err := readPassword("Repeat new password: ")
+		if err != nil {
+			return err
+		}
+
+		if newPassword != repeated {
+			return errors.New("the passwords do not match")
+		}
+
+		err = key.SetPassword(password, newPassword)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("Password set")
+		return nil
+	})
+}
+
+type passwordClearCommand struct{}
+
+func (c *passwordClearCommand) Execute(args []string) error {
+	return withYubiKey(func(key yubikey.YubiKey, password string) error {
+		err := key.SetPassword(password, "")
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("Password removed")
+		return nil
+	})
+}
+
+type passwordForgetCommand struct {
+	All bool `long:"all" description:"Remove the remembered passwords of all YubiKeys, no YubiKey has to be inserted"`
+}
+
+func (c *passwordForgetCommand) Execute(args []string) error {
+	store := keyring.DefaultStore()
+	if store == nil {
+		return errors.New("remembering passwords is not supported on this platform")
+	}
+
+	if c.All {
+		err := store.DeleteAll()
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("Remembered passwords removed")
+		return nil
+	}
+
+	return withFirstYubiKey(func(key yubikey.YubiKey) error {
+		deviceId, err := key.DeviceId()
+		if err != nil {
+			return err
+		}
+
+		err = store.Delete(deviceId)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("Remembered password removed")
+		return nil
+	})
+}
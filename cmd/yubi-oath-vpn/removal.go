@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/MeneDev/yubi-oath-vpn/netctrl"
+	"github.com/rs/zerolog/log"
+)
+
+const removalNothing = "nothing"
+const removalDisconnect = "disconnect"
+
+// RemovalOptions configure what happens to the connection when the YubiKey is removed
+type RemovalOptions struct {
+	OnRemoval          string        `required:"no" long:"on-removal" choice:"nothing" choice:"disconnect" default:"nothing" description:"What to do with the connection when the last YubiKey used for it is removed"`
+	RemovalGracePeriod time.Duration `required:"no" long:"removal-grace-period" default:"0s" description:"With --on-removal disconnect, show a countdown that can be canceled before disconnecting"`
+}
+
+// removalPolicy closes the connection when no YubiKey to establish it with is left
+type removalPolicy struct {
+	options    RemovalOptions
+	connection string
+	network    netctrl.NetworkController
+	countdown  func(ctx context.Context, serial uint32, gracePeriod time.Duration) <-chan bool
+	connected  func() (bool, error)
+	// readers of the inserted keys the connection can be established with
+	readers       map[string]bool
+	cancelPending context.CancelFunc
+}
+
+func removalPolicyNew(options RemovalOptions, connection string, network netctrl.NetworkController, countdown func(ctx context.Context, serial uint32, gracePeriod time.Duration) <-chan bool, connected func() (bool, error)) *removalPolicy {
+	return &removalPolicy{options: options, connection: connection, network: network, countdown: countdown, connected: connected, readers: make(map[string]bool)}
+}
+
+// keyInserted tracks a key the connection can be established with, inserting it cancels a pending disconnect
+func (p *removalPolicy) keyInserted(readerId string) {
+	p.readers[readerId] = true
+
+	if p.cancelPending != nil {
+		log.Info().Str("reader", readerId).Msg("YubiKey inserted again, canceling disconnect")
+		p.cancelPending()
+		p.cancelPending = nil
+	}
+}
+
+// keyRemoved disconnects when the last key was removed, serial is the serial of the removed key or 0 if it is unknown
+func (p *removalPolicy) keyRemoved(ctx context.Context, readerId string, serial uint32) {
+	if !p.readers[readerId] {
+		return
+	}
+	delete(p.readers, readerId)
+
+	if p.options.OnRemoval != removalDisconnect || len(p.readers) > 0 {
+		return
+	}
+
+	connected, err := p.connected()
+	if err != nil {
+		log.Warn().Err(err).Msg("cannot determine whether the connection is active, disconnecting anyway")
+	} else if !connected {
+		log.Info().Str("connection", p.connection).Msg("YubiKey removed, connection is not active")
+		return
+	}
+
+	if p.cancelPending != nil {
+		p.cancelPending()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	p.cancelPending = cancel
+
+	go func() {
+		defer cancel()
+
+		if p.options.RemovalGracePeriod > 0 {
+			log.Info().Dur("grace_period", p.options.RemovalGracePeriod).Msg("YubiKey removed, disconnecting after grace period")
+			if !<-p.countdown(ctx, serial, p.options.RemovalGracePeriod) {
+				return
+			}
+		}
+
+		log.Info().Str("connection", p.connection).Msg("YubiKey removed, disconnecting")
+		err := p.network.Disconnect(ctx, p.connection)
+		if err != nil {
+			log.Error().Err(err).Str("connection", p.connection).Msg("cannot disconnect")
+		}
+	}()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MeneDev/yubi-oath-vpn/netctrl"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ netctrl.NetworkController = (*fakeNetworkController)(nil)
+
+type fakeNetworkController struct {
+	disconnected chan string
+	inactive     bool
+}
+
+func (f *fakeNetworkController) isConnected() (bool, error) {
+	return !f.inactive, nil
+}
+
+func (f *fakeNetworkController) Connect(ctx context.Context, connectionName string, code string) {}
+
+func (f *fakeNetworkController) Disconnect(ctx context.Context, connectionName string) error {
+	f.disconnected <- connectionName
+	return nil
+}
+
+func (f *fakeNetworkController) ConnectionResults() <-chan netctrl.ConnectionAttemptResult {
+	return nil
+}
+
+// countdownUntilCanceled elapses after the grace period unless ctx is canceled before
+func countdownUntilCanceled(ctx context.Context, serial uint32, gracePeriod time.Duration) <-chan bool {
+	result := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			result <- false
+		case <-time.After(gracePeriod):
+			result <- true
+		}
+	}()
+	return result
+}
+
+func TestRemovalPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	newPolicy := func(options RemovalOptions) (*removalPolicy, chan string) {
+		network := &fakeNetworkController{disconnected: make(chan string, 1)}
+		return removalPolicyNew(options, "vpn", network, countdownUntilCanceled, network.isConnected), network.disconnected
+	}
+
+	t.Run("nothing", func(t *testing.T) {
+		policy, disconnected := newPolicy(RemovalOptions{OnRemoval: removalNothing})
+		policy.keyInserted("reader0")
+		policy.keyRemoved(ctx, "reader0", 0)
+
+		assert.Never(t, func() bool { return len(disconnected) > 0 }, 50*time.Millisecond, 5*time.Millisecond)
+	})
+
+	t.Run("disconnect when the last key is removed", func(t *testing.T) {
+		policy, disconnected := newPolicy(RemovalOptions{OnRemoval: removalDisconnect})
+		policy.keyInserted("reader0")
+		policy.keyInserted("reader1")
+
+		policy.keyRemoved(ctx, "reader1", 0)
+		policy.keyRemoved(ctx, "unknown", 0)
+		assert.Never(t, func() bool { return len(disconnected) > 0 }, 50*time.Millisecond, 5*time.Millisecond)
+
+		policy.keyRemoved(ctx, "reader0", 0)
+		assert.Equal(t, "vpn", <-disconnected)
+	})
+
+	t.Run("disconnect after grace period", func(t *testing.T) {
+		policy, disconnected := newPolicy(RemovalOptions{OnRemoval: removalDisconnect, RemovalGracePeriod: 10 * time.Millisecond})
+		policy.keyInserted("reader0")
+		policy.keyRemoved(ctx, "reader0", 0)
+
+		assert.Equal(t, "vpn", <-disconnected)
+	})
+
+	t.Run("countdown gets the serial of the removed key", func(t *testing.T) {
+		serials := make(chan uint32, 1)
+		countdown := func(ctx context.Context, serial uint32, gracePeriod time.Duration) <-chan bool {
+			serials <- serial
+			return countdownUntilCanceled(ctx, serial, gracePeriod)
+		}
+		network := &fakeNetworkController{disconnected: make(chan string, 1)}
+		policy := removalPolicyNew(RemovalOptions{OnRemoval: removalDisconnect, RemovalGracePeriod: time.Millisecond}, "vpn", network, countdown, network.isConnected)
+		policy.keyInserted("reader0")
+		policy.keyRemoved(ctx, "reader0", 1234)
+
+		assert.Equal(t, uint32(1234), <-serials)
+		assert.Equal(t, "vpn", <-network.disconnected)
+	})
+
+	t.Run("inserting the key again cancels the disconnect", func(t *testing.T) {
+		policy, disconnected := newPolicy(RemovalOptions{OnRemoval: removalDisconnect, RemovalGracePeriod: 50 * time.Millisecond})
+		policy.keyInserted("reader0")
+		policy.keyRemoved(ctx, "reader0", 0)
+		policy.keyInserted("reader0")
+
+		assert.Never(t, func() bool { return len(disconnected) > 0 }, 100*time.Millisecond, 5*time.Millisecond)
+	})
+	t.Run("no countdown and no disconnect when the connection is not active", func(t *testing.T) {
+		counted := make(chan uint32, 1)
+		countdown := func(ctx context.Context, serial uint32, gracePeriod time.Duration) <-chan bool {
+			counted <- serial
+			return countdownUntilCanceled(ctx, serial, gracePeriod)
+		}
+		network := &fakeNetworkController{disconnected: make(chan string, 1), inactive: true}
+		policy := removalPolicyNew(RemovalOptions{OnRemoval: removalDisconnect, RemovalGracePeriod: time.Millisecond}, "vpn", network, countdown, network.isConnected)
+		policy.keyInserted("reader0")
+		policy.keyRemoved(ctx, "reader0", 0)
+
+		assert.Never(t, func() bool { return len(counted) > 0 || len(network.disconnected) > 0 }, 50*time.Millisecond, 5*time.Millisecond)
+	})
+}
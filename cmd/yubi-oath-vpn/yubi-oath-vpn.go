@@ -11,8 +11,8 @@ import (
 
 	"github.com/MeneDev/yubi-oath-vpn/githubreleasemon"
 	"github.com/MeneDev/yubi-oath-vpn/gui2"
+	"github.com/MeneDev/yubi-oath-vpn/keyring"
 	"github.com/MeneDev/yubi-oath-vpn/netctrl"
-	"github.com/MeneDev/yubi-oath-vpn/yubikey"
 	"github.com/MeneDev/yubi-oath-vpn/yubimonitor"
 	"github.com/jessevdk/go-flags"
 	"github.com/rs/zerolog"
@@ -45,18 +45,52 @@ func main() {
 	zerolog.SetGlobalLevel(zerolog.InfoLevel)
 
 	var opts Options
-	_, err := flags.NewParser(&opts, flags.HelpFlag|flags.PassDoubleDash).Parse()
+	parser := flags.NewParser(&opts, flags.HelpFlag|flags.PassDoubleDash)
+	parser.SubcommandsOptional = true
+	parser.CommandHandler = func(command flags.Commander, args []string) error {
+		if opts.Debug {
+			zerolog.SetGlobalLevel(zerolog.DebugLevel)
+		}
+
+		if command == nil {
+			return nil
+		}
+		return command.Execute(args)
+	}
+
+	if err := addCredsCommand(parser); err != nil {
+		log.Fatal().Err(err).Msg("cannot create commands")
+	}
+	if err := addPasswordCommand(parser); err != nil {
+		log.Fatal().Err(err).Msg("cannot create commands")
+	}
+	if err := addInfoCommand(parser); err != nil {
+		log.Fatal().Err(err).Msg("cannot create commands")
+	}
+
+	_, err := parser.Parse()
 	if opts.ShowVersion {
 		showVersion()
 		os.Exit(0)
 	}
 
 	if err != nil {
-		log.Fatal().Err(err).Msg("cannot parse flags")
+		if _, ok := err.(*flags.Error); ok {
+			log.Fatal().Err(err).Msg("cannot parse flags")
+		}
+		log.Fatal().Err(err).Msg("command failed")
 	}
 
-	if opts.Debug {
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	if parser.Active != nil {
+		return
+	}
+
+	if opts.ConnectionName == "" {
+		log.Fatal().Msg("the required flag `-c, --connection' was not specified")
+	}
+
+	if err := opts.KeyFilter.validate(); err != nil {
+		log.Fatal().Err(err).Msg("invalid YubiKey filter")
 	}
 
 	ctx := context.Background()
@@ -71,13 +105,14 @@ func main() {
 	yubiChan := yubiMon.InsertionChannel()
 
 	title := fmt.Sprintf("Yubi VPN Mon %s", Version)
-	controller, e := gui2.GuiControllerNew(ctx, title)
+	controller, e := gui2.GuiControllerNew(ctx, title, opts.MinCodeValidity, opts.ClockOffset, keyring.DefaultStore())
 	if e != nil {
 		log.Error().Err(e).Msg("cannot creat GUI")
 		return
 	}
 
 	networkController := netctrl.DefaultNetworkController(ctx)
+	removal := removalPolicyNew(opts.RemovalOptions, opts.ConnectionName, networkController, controller.DisconnectCountdown, isConnectedToTun)
 
 	releaseMon, err := githubreleasemon.GithubReleaseMonNew(ctx, "MeneDev", "yubi-oath-vpn")
 	if err != nil {
@@ -107,7 +142,8 @@ func main() {
 			}
 			log.Debug().Interface("key", key).Msg("yubiEvent.Open")
 
-			if applicableYubiKey(key) {
+			if applicableYubiKey(key, opts.KeyFilter, opts.SlotName) {
+				removal.keyInserted(yubiEvent.Id())
 				connectedToTun, _ := isConnectedToTun()
 				if !connectedToTun {
 					controller.ConnectWith(key, opts.ConnectionName, opts.SlotName)
@@ -116,6 +152,14 @@ func main() {
 				}
 			}
 
+		case removalEvent := <-yubiMon.RemovalChannel():
+			if removalEvent == nil {
+				log.Debug().Msg("removal channel is closed")
+				return
+			}
+			log.Info().Str("reader", removalEvent.Id()).Uint32("serial", removalEvent.Serial()).Time("time", removalEvent.Time()).Msg("YubiKey removed")
+			removal.keyRemoved(ctx, removalEvent.Id(), removalEvent.Serial())
+
 		case conParams := <-controller.InitializeConnection():
 			networkController.Connect(conParams.Context, conParams.ConnectionId, conParams.Code)
 
@@ -138,10 +182,6 @@ func main() {
 	}
 }
 
-func applicableYubiKey(key yubikey.YubiKey) bool {
-	return true
-}
-
 func isConnectedToTun() (bool, error) {
 	ifaces, err := net.Interfaces()
 	if err != nil {
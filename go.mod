@@ -14,6 +14,7 @@ require (
 	github.com/stretchr/testify v1.8.0
 	golang.org/x/crypto v0.0.0-20220824171710-5757bc0c5503
 	golang.org/x/sys v0.0.0-20220823224334-20c2bfdbfe24
+	golang.org/x/term v0.0.0-20220722155259-a9ba230a4035
 )
 
 require (
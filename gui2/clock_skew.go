@@ -0,0 +1,144 @@
+package gui2
+
+import (
+	"time"
+
+	"github.com/MeneDev/yubi-oath-vpn/oath"
+	"github.com/rs/zerolog/log"
+)
+
+// adjacentWindowRetry tracks the retries of a rejected TOTP code with the adjacent time steps
+type adjacentWindowRetry struct {
+	// rejected is the submission of the code calculated with baseOffset
+	rejected   submission
+	baseOffset time.Duration
+	// offsets are the clock offsets that were not tried yet
+	offsets []time.Duration
+	// tried are the rejected codes of this retry, their steps are not submitted again
+	tried []oath.Code
+}
+
+// adjacentWindowRetryNew tries the next and then the previous time step of the rejected code, each once
+func adjacentWindowRetryNew(rejected submission, baseOffset time.Duration) *adjacentWindowRetry {
+	period := time.Duration(rejected.code.Period) * time.Second
+	return &adjacentWindowRetry{rejected: rejected, baseOffset: baseOffset, offsets: []time.Duration{baseOffset + period, baseOffset - period}, tried: []oath.Code{rejected.code}}
+}
+
+// rejectedCodes returns the codes rejected during the retry, nil without a retry
+func (r *adjacentWindowRetry) rejectedCodes() []oath.Code {
+	if r == nil {
+		return nil
+	}
+	return append([]oath.Code{}, r.tried...)
+}
+
+// stepRejected reports if the step of the code was rejected before
+func stepRejected(rejected []oath.Code, code oath.Code) bool {
+	for _, other := range rejected {
+		if other.Name == code.Name && other.Step == code.Step {
+			return true
+		}
+	}
+	return false
+}
+
+// next returns the offset to try next, false when all offsets were tried
+func (r *adjacentWindowRetry) next() (time.Duration, bool) {
+	if len(r.offsets) == 0 {
+		return 0, false
+	}
+
+	offset := r.offsets[0]
+	r.offsets = r.offsets[1:]
+	return offset, true
+}
+
+// retryAdjacentWindow retries a code the server rejected once with the next and once with the previous time step,
+// because the clock of the computer might be off. Other failures, e.g. of the network, are not retried because
+// every submitted code counts towards a lockout. The offset is only kept when a retried code is accepted.
+func (ctrl *guiController) retryAdjacentWindow(authFailed bool) bool {
+	if !authFailed || !ctrl.states.Can(evRetryAdjacentWindow) {
+		return false
+	}
+
+	retry := ctrl.adjacentWindowRetry
+	if retry == nil {
+		rejected := ctrl.lastSubmission
+		if rejected == nil {
+			return false
+		}
+
+		retry = adjacentWindowRetryNew(*rejected, ctrl.clock.Offset())
+		ctrl.adjacentWindowRetry = retry
+	}
+
+	// the rejected codes were not accepted, so the steps submitted before them count again
+	if ctrl.lastSubmission != nil {
+		ctrl.unmarkSubmitted(*ctrl.lastSubmission)
+		retry.tried = append(retry.tried, ctrl.lastSubmission.code)
+	}
+	ctrl.unmarkSubmitted(retry.rejected)
+	ctrl.lastSubmission = nil
+
+	offset, ok := ctrl.nextAdjacentOffset(retry)
+	if !ok {
+		log.Info().Msg("codes of the adjacent time steps were rejected as well or cannot be submitted")
+		ctrl.abandonAdjacentWindowRetry()
+		return false
+	}
+
+	log.Info().Dur("offset", offset).Msg("code was rejected, retrying with the adjacent time step")
+	ctrl.clock.SetOffset(offset)
+
+	// the key stays unlocked while it is inserted, the password is not needed again
+	err := ctrl.states.Event(evRetryAdjacentWindow, "")
+	if err != nil {
+		log.Error().Err(err).Msg("dispatchEvent error")
+	}
+	return true
+}
+
+// nextAdjacentOffset skips offsets whose step was submitted before or rejected, waiting for the next step would
+// submit a rejected step again
+func (ctrl *guiController) nextAdjacentOffset(retry *adjacentWindowRetry) (time.Duration, bool) {
+	for {
+		offset, ok := retry.next()
+		if !ok {
+			return 0, false
+		}
+
+		rejected := retry.rejected.code
+		now := ctrl.clock.Now().Add(offset - ctrl.clock.Offset())
+		candidate := oath.TotpCode(rejected.Name, "", now, rejected.Period)
+		if !ctrl.stepUsed(candidate) && !stepRejected(retry.tried, candidate) {
+			return offset, true
+		}
+
+		log.Info().Dur("offset", offset).Uint64("step", candidate.Step).Msg("step of the adjacent code was already submitted, skipping it")
+	}
+}
+
+// acceptAdjacentWindowRetry keeps the offset that produced an accepted code
+func (ctrl *guiController) acceptAdjacentWindowRetry() {
+	if ctrl.adjacentWindowRetry == nil {
+		return
+	}
+
+	log.Info().Dur("offset", ctrl.clock.Offset()).Msg("learned clock offset from accepted code")
+	ctrl.adjacentWindowRetry = nil
+}
+
+// abandonAdjacentWindowRetry restores the clock offset and the submitted step of the first rejected code
+func (ctrl *guiController) abandonAdjacentWindowRetry() {
+	retry := ctrl.adjacentWindowRetry
+	if retry == nil {
+		return
+	}
+
+	ctrl.clock.SetOffset(retry.baseOffset)
+	if ctrl.lastSubmission != nil {
+		ctrl.unmarkSubmitted(*ctrl.lastSubmission)
+	}
+	ctrl.markSubmitted(retry.rejected.code)
+	ctrl.adjacentWindowRetry = nil
+}
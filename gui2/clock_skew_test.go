@@ -0,0 +1,101 @@
+package gui2
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MeneDev/yubi-oath-vpn/oath"
+	"github.com/looplab/fsm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdjacentWindowRetry_Next(t *testing.T) {
+	code := oath.TotpCode("totp", "123456", time.Unix(59, 0), 30)
+	retry := adjacentWindowRetryNew(submission{code: code}, 10*time.Second)
+
+	offset, ok := retry.next()
+	assert.True(t, ok)
+	assert.Equal(t, 40*time.Second, offset)
+
+	offset, ok = retry.next()
+	assert.True(t, ok)
+	assert.Equal(t, -20*time.Second, offset)
+
+	_, ok = retry.next()
+	assert.False(t, ok)
+}
+
+// retryController only has the state machine transitions used by the retry, entering states has no side effects
+func retryController(offset time.Duration) *guiController {
+	ctrl := &guiController{submittedSteps: make(map[string]uint64), clock: oath.OffsetClockNew(oath.FixedClock{Time: time.Unix(59, 0)}, offset)}
+	ctrl.states = fsm.NewFSM(stateConnecting, fsm.Events{
+		{Name: evRetryAdjacentWindow, Src: []string{stateConnecting}, Dst: stateCalculating},
+	}, fsm.Callbacks{})
+	ctrl.markSubmitted(oath.TotpCode("totp", "123456", ctrl.clock.Now(), 30))
+	return ctrl
+}
+
+func TestGuiController_RetryAdjacentWindow(t *testing.T) {
+	t.Run("other failures are not retried", func(t *testing.T) {
+		ctrl := retryController(0)
+
+		assert.False(t, ctrl.retryAdjacentWindow(false))
+		assert.Equal(t, stateConnecting, ctrl.states.Current())
+		assert.Equal(t, time.Duration(0), ctrl.clock.Offset())
+	})
+
+	t.Run("each adjacent step is tried once", func(t *testing.T) {
+		ctrl := retryController(0)
+
+		assert.True(t, ctrl.retryAdjacentWindow(true))
+		assert.Equal(t, 30*time.Second, ctrl.clock.Offset())
+
+		ctrl.states.SetState(stateConnecting)
+		ctrl.markSubmitted(oath.TotpCode("totp", "234567", ctrl.clock.Now(), 30))
+		assert.True(t, ctrl.retryAdjacentWindow(true))
+		assert.Equal(t, -30*time.Second, ctrl.clock.Offset())
+
+		ctrl.states.SetState(stateConnecting)
+		ctrl.markSubmitted(oath.TotpCode("totp", "345678", ctrl.clock.Now(), 30))
+		assert.False(t, ctrl.retryAdjacentWindow(true))
+		assert.Equal(t, time.Duration(0), ctrl.clock.Offset(), "offset of rejected codes is not kept")
+		assert.True(t, ctrl.stepUsed(oath.TotpCode("totp", "123456", time.Unix(59, 0), 30)))
+	})
+
+	t.Run("steps that were submitted before are not retried", func(t *testing.T) {
+		ctrl := &guiController{submittedSteps: make(map[string]uint64), clock: oath.OffsetClockNew(oath.FixedClock{Time: time.Unix(59, 0)}, 0)}
+		ctrl.states = fsm.NewFSM(stateConnecting, stateEvents, fsm.Callbacks{})
+		// the code of the previous step was accepted by an earlier connection
+		ctrl.markSubmitted(oath.TotpCode("totp", "012345", time.Unix(29, 0), 30))
+		ctrl.markSubmitted(oath.TotpCode("totp", "123456", ctrl.clock.Now(), 30))
+
+		assert.True(t, ctrl.retryAdjacentWindow(true))
+		assert.Equal(t, 30*time.Second, ctrl.clock.Offset())
+
+		ctrl.states.SetState(stateConnecting)
+		ctrl.markSubmitted(oath.TotpCode("totp", "234567", ctrl.clock.Now(), 30))
+		assert.False(t, ctrl.retryAdjacentWindow(true))
+		assert.Equal(t, time.Duration(0), ctrl.clock.Offset())
+	})
+
+	t.Run("offset of an accepted code is kept", func(t *testing.T) {
+		ctrl := retryController(0)
+
+		assert.True(t, ctrl.retryAdjacentWindow(true))
+		ctrl.acceptAdjacentWindowRetry()
+		ctrl.abandonAdjacentWindowRetry()
+
+		assert.Equal(t, 30*time.Second, ctrl.clock.Offset())
+	})
+}
+
+func TestStepRejected(t *testing.T) {
+	code := oath.TotpCode("totp", "123456", time.Unix(59, 0), 30)
+	retry := adjacentWindowRetryNew(submission{code: code}, 0)
+	rejected := retry.rejectedCodes()
+
+	assert.True(t, stepRejected(rejected, oath.TotpCode("totp", "654321", time.Unix(31, 0), 30)))
+	assert.False(t, stepRejected(rejected, oath.TotpCode("totp", "654321", time.Unix(29, 0), 30)))
+	assert.False(t, stepRejected(rejected, oath.TotpCode("other", "654321", time.Unix(31, 0), 30)))
+	assert.Nil(t, (*adjacentWindowRetry)(nil).rejectedCodes())
+}
@@ -0,0 +1,93 @@
+package gui2
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/MeneDev/yubi-oath-vpn/oath"
+	"github.com/MeneDev/yubi-oath-vpn/yubikey"
+	"github.com/gotk3/gotk3/glib"
+	"github.com/rs/zerolog/log"
+)
+
+// calculateCode calculates a code that can be submitted: it stays valid for at least minCodeValidity,
+// because sending it can take several seconds, and its time step was not submitted or rejected before,
+// because many servers reject a code that was already accepted
+func (ctrl *guiController) calculateCode(ctx context.Context, key yubikey.YubiKey, password string, slotName string, rejected []oath.Code, touchRequired func()) (oath.Code, error) {
+	for {
+		code, err := key.GetCodeWithPassword(password, slotName, ctrl.clock, touchRequired)
+		if err != nil || !code.Expires() {
+			return code, err
+		}
+
+		remaining := code.Remaining(ctrl.clock.Now())
+
+		var message string
+		if ctrl.stepUsed(code) || stepRejected(rejected, code) {
+			log.Info().Str("slot", code.Name).Uint64("step", code.Step).Dur("remaining", remaining).Msg("code was already submitted, waiting for the next time step")
+			message = fmt.Sprintf("This code was already used, waiting %d s for the next one...", int(remaining.Seconds())+1)
+		} else if remaining < ctrl.minCodeValidity {
+			log.Info().Dur("remaining", remaining).Dur("min_validity", ctrl.minCodeValidity).Msg("code expires soon, waiting for the next time step")
+			message = fmt.Sprintf("Code expires in %d s, waiting for the next one...", int(remaining.Seconds()))
+		} else {
+			return code, nil
+		}
+
+		ctrl.showWaiting(message)
+
+		select {
+		case <-ctx.Done():
+			return oath.Code{}, ctx.Err()
+		case <-time.After(remaining):
+		}
+	}
+}
+
+// stepUsed reports if a code of the same or a later time step of the credential was submitted
+func (ctrl *guiController) stepUsed(code oath.Code) bool {
+	ctrl.submittedStepsMu.Lock()
+	defer ctrl.submittedStepsMu.Unlock()
+
+	step, ok := ctrl.submittedSteps[code.Name]
+	return ok && step >= code.Step
+}
+
+// submission is a submitted code and the step that was recorded for its credential before
+type submission struct {
+	code         oath.Code
+	previousStep uint64
+	hadPrevious  bool
+}
+
+func (ctrl *guiController) markSubmitted(code oath.Code) {
+	ctrl.submittedStepsMu.Lock()
+	defer ctrl.submittedStepsMu.Unlock()
+
+	if !code.Expires() {
+		ctrl.lastSubmission = nil
+		return
+	}
+
+	previousStep, hadPrevious := ctrl.submittedSteps[code.Name]
+	ctrl.lastSubmission = &submission{code: code, previousStep: previousStep, hadPrevious: hadPrevious}
+	ctrl.submittedSteps[code.Name] = code.Step
+}
+
+// unmarkSubmitted restores the step recorded before the code was submitted
+func (ctrl *guiController) unmarkSubmitted(s submission) {
+	ctrl.submittedStepsMu.Lock()
+	defer ctrl.submittedStepsMu.Unlock()
+
+	if s.hadPrevious {
+		ctrl.submittedSteps[s.code.Name] = s.previousStep
+	} else {
+		delete(ctrl.submittedSteps, s.code.Name)
+	}
+}
+
+func (ctrl *guiController) showWaiting(message string) {
+	glib.IdleAdd(func() {
+		ctrl.gtkGui.lblConnect.SetLabel(message)
+	})
+}
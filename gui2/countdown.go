@@ -0,0 +1,155 @@
+package gui2
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gotk3/gotk3/glib"
+	"github.com/looplab/fsm"
+	"github.com/rs/zerolog/log"
+)
+
+// disconnectCountdown is shown while waiting to close the connection after the YubiKey was removed
+type disconnectCountdown struct {
+	cancel   context.CancelFunc
+	deadline time.Time
+	// serial of the removed key, inserting it again cancels the countdown
+	serial uint32
+	// done is closed when the countdown elapsed or was canceled
+	done chan struct{}
+}
+
+// DisconnectCountdown shows a countdown that the user can cancel, the result is true when the countdown elapsed and
+// false when it was canceled by the user, by inserting the key with the serial again or by ctx
+func (ctrl *guiController) DisconnectCountdown(ctx context.Context, serial uint32, gracePeriod time.Duration) <-chan bool {
+	ctx, cancel := context.WithCancel(ctx)
+	countdown := &disconnectCountdown{cancel: cancel, deadline: time.Now().Add(gracePeriod), serial: serial, done: make(chan struct{})}
+	result := make(chan bool, 1)
+
+	ctrl.sendEvent(evRemovalCountdown, countdown)
+
+	go func() {
+		defer cancel()
+		defer close(countdown.done)
+
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			remaining := time.Until(countdown.deadline)
+			if remaining <= 0 {
+				log.Info().Msg("disconnect countdown elapsed")
+				result <- true
+				ctrl.sendEvent(evCountdownDone, countdown)
+				return
+			}
+
+			if ctrl.states.Current() == stateCountdown {
+				ctrl.showWaiting(countdown.message())
+			}
+
+			select {
+			case <-ctx.Done():
+				log.Info().Msg("disconnect countdown canceled")
+				result <- false
+				ctrl.sendEvent(evCountdownDone, countdown)
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return result
+}
+
+// finished reports if the countdown elapsed or was canceled, events are delivered out of order so the start of the
+// countdown may arrive after it finished
+func (countdown *disconnectCountdown) finished() bool {
+	select {
+	case <-countdown.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (countdown *disconnectCountdown) message() string {
+	remaining := time.Until(countdown.deadline)
+	return fmt.Sprintf("YubiKey removed, disconnecting in %d s...", int(remaining.Seconds()+0.5))
+}
+
+// removalCountdown shows the countdown, it replaces whatever the dialog shows because the key it was used for is gone
+func (ctrl *guiController) removalCountdown(countdown *disconnectCountdown) {
+	if countdown.finished() {
+		log.Debug().Msg("disconnect countdown finished before it was shown")
+		return
+	}
+
+	replaced := ctrl.states.Current() == stateCountdown
+	ctrl.countdown = countdown
+	if replaced {
+		return
+	}
+
+	err := ctrl.states.Event(evRemovalCountdown)
+	if err != nil {
+		log.Error().Err(err).Msg("dispatchEvent error")
+		ctrl.countdown = nil
+	}
+}
+
+// countdownDone hides the countdown and continues with another inserted key
+func (ctrl *guiController) countdownDone(countdown *disconnectCountdown) {
+	if countdown != ctrl.countdown || ctrl.states.Current() != stateCountdown {
+		return
+	}
+
+	err := ctrl.states.Event(evCountdownDone)
+	if err != nil {
+		log.Error().Err(err).Msg("dispatchEvent error")
+	}
+
+	ctrl.activateRemainingKey()
+}
+
+// cancelCountdownFor cancels the countdown when the removed key is inserted again
+func (ctrl *guiController) cancelCountdownFor(k *insertedKey) bool {
+	if ctrl.states.Current() != stateCountdown || k.serial == 0 || k.serial != ctrl.countdown.serial {
+		return false
+	}
+
+	log.Info().Uint32("serial", k.serial).Msg("YubiKey inserted again, canceling disconnect countdown")
+	err := ctrl.states.Event(evCancel)
+	if err != nil {
+		log.Error().Err(err).Msg("dispatchEvent error")
+	}
+	return true
+}
+
+func (ctrl *guiController) enterCountdown(e *fsm.Event) {
+	message := ctrl.countdown.message()
+
+	ctrl.gtkGui.reset()
+	glib.IdleAdd(func() {
+		ctrl.gtkGui.boxConnecting.SetVisible(true)
+		ctrl.gtkGui.lblConnect.SetLabel(message)
+		ctrl.gtkGui.btnConnect.SetSensitive(false)
+		ctrl.gtkGui.txtPassword.SetSensitive(false)
+	})
+	ctrl.gtkGui.show()
+}
+
+func (ctrl *guiController) leaveCountdown(e *fsm.Event) {
+	// the countdown is canceled with the cancel button, escape, by closing the dialog or by inserting the key again
+	if e.Event == evCancel {
+		ctrl.countdown.cancel()
+	}
+	ctrl.countdown = nil
+
+	glib.IdleAdd(func() {
+		ctrl.gtkGui.boxConnecting.SetVisible(false)
+		ctrl.gtkGui.lblConnect.SetText("")
+		ctrl.gtkGui.txtPassword.SetSensitive(true)
+	})
+}
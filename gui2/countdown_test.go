@@ -0,0 +1,74 @@
+package gui2
+
+import (
+	"testing"
+
+	"github.com/looplab/fsm"
+	"github.com/stretchr/testify/assert"
+)
+
+// countdownController has the transitions of the dialog, only leaving the countdown has side effects
+func countdownController(state string) *guiController {
+	ctrl := &guiController{}
+	ctrl.states = fsm.NewFSM(state, stateEvents, fsm.Callbacks{
+		"leave_" + stateCountdown: ctrl.leaveCountdown,
+	})
+	return ctrl
+}
+
+func countdownNew(serial uint32) (*disconnectCountdown, *bool) {
+	canceled := false
+	return &disconnectCountdown{cancel: func() { canceled = true }, serial: serial, done: make(chan struct{})}, &canceled
+}
+
+func TestGuiController_RemovalCountdown(t *testing.T) {
+	t.Run("replaces a dialog in use", func(t *testing.T) {
+		for _, state := range []string{stateHidden, stateAskPass, stateCalculating, stateTouch, stateConnecting} {
+			ctrl := countdownController(state)
+			countdown, _ := countdownNew(1)
+
+			ctrl.removalCountdown(countdown)
+
+			assert.Equal(t, stateCountdown, ctrl.states.Current(), state)
+			assert.Same(t, countdown, ctrl.countdown)
+		}
+	})
+
+	t.Run("is not shown after it finished", func(t *testing.T) {
+		ctrl := countdownController(stateHidden)
+		countdown, _ := countdownNew(1)
+		close(countdown.done)
+
+		ctrl.removalCountdown(countdown)
+		ctrl.countdownDone(countdown)
+
+		assert.Equal(t, stateHidden, ctrl.states.Current())
+	})
+
+	t.Run("ignores a previous countdown", func(t *testing.T) {
+		ctrl := countdownController(stateHidden)
+		previous, _ := countdownNew(1)
+		countdown, _ := countdownNew(1)
+
+		ctrl.removalCountdown(previous)
+		ctrl.removalCountdown(countdown)
+		ctrl.countdownDone(previous)
+		assert.Equal(t, stateCountdown, ctrl.states.Current())
+
+		ctrl.countdownDone(countdown)
+		assert.Equal(t, stateHidden, ctrl.states.Current())
+	})
+
+	t.Run("is canceled by inserting the key again", func(t *testing.T) {
+		ctrl := countdownController(stateHidden)
+		countdown, canceled := countdownNew(1234)
+		ctrl.removalCountdown(countdown)
+
+		assert.False(t, ctrl.cancelCountdownFor(&insertedKey{serial: 5678}))
+		assert.False(t, *canceled)
+
+		assert.True(t, ctrl.cancelCountdownFor(&insertedKey{serial: 1234}))
+		assert.True(t, *canceled)
+		assert.Equal(t, stateHidden, ctrl.states.Current())
+	})
+}
@@ -29,6 +29,9 @@ type gtkGui struct {
 	btnCancel      *gtk.Button
 	boxRoot        *gtk.Box
 	lnkUpdate      *gtk.LinkButton
+	lblDevice      *gtk.Label
+	cmbKey         *gtk.ComboBoxText
+	chkRemember    *gtk.CheckButton
 }
 
 func (g gtkGui) hide() {
@@ -89,12 +92,50 @@ func (g gtkGui) SetVersion(release githubreleasemon.Release) {
 	})
 }
 
+func (g gtkGui) SetDeviceInfo(info string) {
+	glib.IdleAdd(func() {
+		g.lblDevice.SetText(info)
+	})
+}
+
+// SetKeys fills the chooser with the inserted keys, it is only visible when there is more than one key
+func (g gtkGui) SetKeys(ids []string, labels []string, activeId string) {
+	glib.IdleAdd(func() {
+		g.cmbKey.RemoveAll()
+		for i := range ids {
+			g.cmbKey.Append(ids[i], labels[i])
+		}
+		g.cmbKey.SetActiveID(activeId)
+		g.cmbKey.SetVisible(len(ids) > 1)
+	})
+}
+
+// SetRememberAvailable shows the option to remember the password, it is only available with a keyring
+func (g gtkGui) SetRememberAvailable(available bool) {
+	glib.IdleAdd(func() {
+		g.chkRemember.SetVisible(available)
+	})
+}
+
+// SetPasswordRequired disables the password entry while the key is unlocked, connecting uses the unlocked key
+func (g gtkGui) SetPasswordRequired(required bool) {
+	glib.IdleAdd(func() {
+		g.txtPassword.SetSensitive(required)
+		if required {
+			g.txtPassword.SetPlaceholderText("")
+		} else {
+			g.txtPassword.SetPlaceholderText("YubiKey is unlocked")
+		}
+	})
+}
+
 type eventHandlers struct {
 	onDestroy           func()
 	onWinKeyPress       func(win *gtk.Window, ev *gdk.Event)
 	onPasswordKeyPress  func(win *gtk.Entry, ev *gdk.Event)
 	onBtnConnectClicked func(btn *gtk.Button)
 	onBtnCancelClicked  func(btn *gtk.Button)
+	onKeyChanged        func(cmb *gtk.ComboBoxText)
 }
 
 func gtkGuiNew(ctx context.Context, title string, handlers eventHandlers) (*gtkGui, error) {
@@ -250,6 +291,33 @@ func gtkGuiNew(ctx context.Context, title string, handlers eventHandlers) (*gtkG
 		lnkUpdate.SetVisible(false)
 		lnkUpdate.SetLabel("")
 
+		objLblDevice, err := builder.GetObject("lblDevice")
+		if err != nil {
+			errCh <- err
+			return
+		}
+		lblDevice := objLblDevice.(*gtk.Label)
+
+		objCmbKey, err := builder.GetObject("cmbKey")
+		if err != nil {
+			errCh <- err
+			return
+		}
+		cmbKey := objCmbKey.(*gtk.ComboBoxText)
+
+		sigHandle = cmbKey.Connect("changed", handlers.onKeyChanged)
+		if sigHandle == 0 {
+			errCh <- errors.New("creating GTK handler for changed on key chooser failed")
+			return
+		}
+
+		objChkRemember, err := builder.GetObject("chkRemember")
+		if err != nil {
+			errCh <- err
+			return
+		}
+		chkRemember := objChkRemember.(*gtk.CheckButton)
+
 		buffer, err := gtk.TextBufferNew(nil)
 		txtError.SetBuffer(buffer)
 
@@ -266,6 +334,9 @@ func gtkGuiNew(ctx context.Context, title string, handlers eventHandlers) (*gtkG
 		g.boxRoot = boxRoot
 		g.boxRoot = boxRoot
 		g.lnkUpdate = lnkUpdate
+		g.lblDevice = lblDevice
+		g.cmbKey = cmbKey
+		g.chkRemember = chkRemember
 
 		errCh <- nil
 		gtk.Main()
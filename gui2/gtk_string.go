@@ -237,6 +237,48 @@ const gtkGuiString = `<?xml version="1.0" encoding="UTF-8"?>
             <property name="position">4</property>
           </packing>
         </child>
+        <child>
+          <object class="GtkLabel" id="lblDevice">
+            <property name="can_focus">False</property>
+            <property name="halign">start</property>
+            <property name="selectable">True</property>
+            <attributes>
+              <attribute name="scale" value="0.8"/>
+            </attributes>
+          </object>
+          <packing>
+            <property name="expand">False</property>
+            <property name="fill">True</property>
+            <property name="position">5</property>
+          </packing>
+        </child>
+        <child>
+          <object class="GtkComboBoxText" id="cmbKey">
+            <property name="can_focus">False</property>
+            <property name="no_show_all">True</property>
+            <property name="tooltip_text" translatable="yes">Choose the YubiKey to use</property>
+          </object>
+          <packing>
+            <property name="expand">False</property>
+            <property name="fill">True</property>
+            <property name="position">6</property>
+          </packing>
+        </child>
+        <child>
+          <object class="GtkCheckButton" id="chkRemember">
+            <property name="label" translatable="yes">Remember password on this computer</property>
+            <property name="can_focus">True</property>
+            <property name="receives_default">False</property>
+            <property name="no_show_all">True</property>
+            <property name="tooltip_text" translatable="yes">Stores a key derived from the password in the desktop keyring</property>
+            <property name="draw_indicator">True</property>
+          </object>
+          <packing>
+            <property name="expand">False</property>
+            <property name="fill">True</property>
+            <property name="position">7</property>
+          </packing>
+        </child>
       </object>
     </child>
   </object>
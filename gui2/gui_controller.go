@@ -2,9 +2,14 @@ package gui2
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/MeneDev/yubi-oath-vpn/githubreleasemon"
+	"github.com/MeneDev/yubi-oath-vpn/keyring"
 	"github.com/MeneDev/yubi-oath-vpn/netctrl"
+	"github.com/MeneDev/yubi-oath-vpn/oath"
 	"github.com/MeneDev/yubi-oath-vpn/yubikey"
 	"github.com/gotk3/gotk3/gdk"
 	"github.com/gotk3/gotk3/gtk"
@@ -23,6 +28,7 @@ type GuiController interface {
 	InitializeConnection() chan ConnectionParameters
 	ConnectionResult(events netctrl.ConnectionAttemptResult)
 	SetLatestVersion(release githubreleasemon.Release)
+	DisconnectCountdown(ctx context.Context, serial uint32, gracePeriod time.Duration) <-chan bool
 }
 
 type guiController struct {
@@ -36,6 +42,18 @@ type guiController struct {
 	connectionId             string
 	slotName                 string
 	cancelCurrentConnection  context.CancelFunc
+	cancelCurrentCalculation context.CancelFunc
+	touchTimer               *time.Timer
+	keys                     []*insertedKey
+	keyCounter               int32
+	minCodeValidity          time.Duration
+	submittedStepsMu         sync.Mutex
+	submittedSteps           map[string]uint64
+	lastSubmission           *submission
+	clock                    *oath.OffsetClock
+	adjacentWindowRetry      *adjacentWindowRetry
+	store                    keyring.Store
+	countdown                *disconnectCountdown
 }
 
 func (ctrl *guiController) SetLatestVersion(release githubreleasemon.Release) {
@@ -47,7 +65,7 @@ func (ctrl *guiController) ConnectionResult(event netctrl.ConnectionAttemptResul
 		ctrl.sendEvent(evConnectionEstablished)
 	} else {
 		log.Error().Str("error", event.String()).Msg("evConnectionError error")
-		ctrl.sendEvent(evConnectionError, event.String())
+		ctrl.sendEvent(evConnectionError, event.String(), event.AuthFailed())
 	}
 }
 
@@ -57,10 +75,14 @@ func (ctrl *guiController) InitializeConnection() chan ConnectionParameters {
 
 var _ GuiController = (*guiController)(nil)
 
-func GuiControllerNew(ctx context.Context, title string) (GuiController, error) {
+// GuiControllerNew creates the dialog, codes that expire within minCodeValidity are not used.
+// Codes are calculated for the system time shifted by clockOffset.
+// Access keys are remembered in the store, a nil store disables remembering passwords.
+func GuiControllerNew(ctx context.Context, title string, minCodeValidity time.Duration, clockOffset time.Duration, store keyring.Store) (GuiController, error) {
 
 	ctx, cancel := context.WithCancel(ctx)
-	controller := &guiController{ctx: ctx, cancel: cancel}
+	controller := &guiController{ctx: ctx, cancel: cancel, minCodeValidity: minCodeValidity, submittedSteps: make(map[string]uint64), store: store}
+	controller.clock = oath.OffsetClockNew(oath.SystemClock{}, clockOffset)
 
 	handlers := eventHandlers{
 		onDestroy:           controller.onDestroy,
@@ -68,6 +90,7 @@ func GuiControllerNew(ctx context.Context, title string) (GuiController, error)
 		onPasswordKeyPress:  controller.onPasswordKeyPress,
 		onWinKeyPress:       controller.onWinKeyPress,
 		onBtnCancelClicked:  controller.onBtnCancelClicked,
+		onKeyChanged:        controller.onKeyChanged,
 	}
 
 	gtkGui, e := gtkGuiNew(ctx, title, handlers)
@@ -77,6 +100,7 @@ func GuiControllerNew(ctx context.Context, title string) (GuiController, error)
 	}
 
 	controller.gtkGui = gtkGui
+	gtkGui.SetRememberAvailable(store != nil)
 	controller.initFsm()
 
 	controller.initializeConnectionChan = make(chan ConnectionParameters)
@@ -106,11 +130,12 @@ func GuiControllerNew(ctx context.Context, title string) (GuiController, error)
 
 func (ctrl *guiController) ConnectWith(key yubikey.YubiKey, connectionId string, slotName string) {
 	log.Debug().Msg("ConnectWith")
-	ctrl.sendEvent(evKeyInserted, key, connectionId, slotName)
+	inserted := insertedKeyNew(int(atomic.AddInt32(&ctrl.keyCounter, 1)), key, connectionId, slotName)
+	ctrl.sendEvent(evKeyInserted, inserted)
 	go func() {
 		<-key.Context().Done()
 
-		ctrl.sendEvent(evKeyRemoved, key)
+		ctrl.sendEvent(evKeyRemoved, inserted)
 	}()
 }
 
@@ -132,19 +157,26 @@ func (ctrl *guiController) onPasswordKeyPress(entry *gtk.Entry, ev *gdk.Event) {
 	if keyEvent.KeyVal() == gdk.KEY_Return {
 
 		text, _ := entry.GetText()
-		ctrl.sendEvent(evPasswordEntered, text)
+		ctrl.sendEvent(evPasswordEntered, text, ctrl.gtkGui.chkRemember.GetActive())
 	}
 }
 
 func (ctrl *guiController) onBtnConnectClicked(ev *gtk.Button) {
 	text, _ := ctrl.gtkGui.txtPassword.GetText()
-	ctrl.sendEvent(evPasswordEntered, text)
+	ctrl.sendEvent(evPasswordEntered, text, ctrl.gtkGui.chkRemember.GetActive())
 }
 
 func (ctrl *guiController) onBtnCancelClicked(ev *gtk.Button) {
 	ctrl.sendEvent(evCancel)
 }
 
+func (ctrl *guiController) onKeyChanged(cmb *gtk.ComboBoxText) {
+	id := cmb.GetActiveID()
+	if id != "" {
+		ctrl.sendEvent(evKeySelected, id)
+	}
+}
+
 func (ctrl *guiController) sendEvent(event string, args ...interface{}) {
 	log.Debug().Msg("sendEvent")
 	data := eventData{event: event, args: args}
@@ -156,6 +188,30 @@ func (ctrl *guiController) sendEvent(event string, args ...interface{}) {
 
 func (ctrl *guiController) dispatchEvent(ev eventData) {
 	log.Debug().Msg("dispatchEvent")
+
+	// the inserted keys are tracked outside of the state machine, only the key in use drives it
+	switch ev.event {
+	case evKeyInserted:
+		ctrl.keyInserted(ev.args[0].(*insertedKey))
+		return
+	case evKeyRemoved:
+		ctrl.keyRemoved(ev.args[0].(*insertedKey))
+		return
+	case evKeySelected:
+		ctrl.keySelected(ev.args[0].(string))
+		return
+	case evConnectionError:
+		if ctrl.retryAdjacentWindow(ev.args[1].(bool)) {
+			return
+		}
+	case evRemovalCountdown:
+		ctrl.removalCountdown(ev.args[0].(*disconnectCountdown))
+		return
+	case evCountdownDone:
+		ctrl.countdownDone(ev.args[0].(*disconnectCountdown))
+		return
+	}
+
 	err := ctrl.states.Event(ev.event, ev.args...)
 	if err != nil {
 		log.Error().Err(err).Msg("dispatchEvent error")
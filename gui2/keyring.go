@@ -0,0 +1,76 @@
+package gui2
+
+import (
+	"errors"
+
+	"github.com/MeneDev/yubi-oath-vpn/keyring"
+	"github.com/MeneDev/yubi-oath-vpn/yubierror"
+	"github.com/MeneDev/yubi-oath-vpn/yubikey"
+	"github.com/rs/zerolog/log"
+)
+
+// unlockWithStoredKey unlocks the key with a remembered access key, a key that is rejected is removed from the store
+func (ctrl *guiController) unlockWithStoredKey(key yubikey.YubiKey) bool {
+	if ctrl.store == nil {
+		return false
+	}
+
+	deviceId, err := key.DeviceId()
+	if err != nil {
+		log.Warn().Err(err).Msg("cannot read device id")
+		return false
+	}
+
+	accessKey, err := ctrl.store.Load(deviceId)
+	if errors.Is(err, keyring.ErrorNotStored) {
+		log.Debug().Msg("no access key stored")
+		return false
+	}
+	if err != nil {
+		log.Warn().Err(err).Msg("cannot load access key from keyring")
+		return false
+	}
+
+	err = key.UnlockWithAccessKey(accessKey)
+	if errors.Is(err, yubierror.ErrorWrongPassword) {
+		log.Info().Msg("stored access key was rejected, removing it from the keyring")
+		if err := ctrl.store.Delete(deviceId); err != nil {
+			log.Warn().Err(err).Msg("cannot remove access key from keyring")
+		}
+		return false
+	}
+	if err != nil {
+		log.Warn().Err(err).Msg("cannot unlock with stored access key")
+		return false
+	}
+
+	log.Info().Msg("unlocked with access key from keyring")
+	return true
+}
+
+// rememberAccessKey stores the access key of the unlocked key, the password itself is never stored
+func (ctrl *guiController) rememberAccessKey(key yubikey.YubiKey) {
+	if ctrl.store == nil {
+		return
+	}
+
+	accessKey := key.AccessKey()
+	if accessKey == nil {
+		log.Debug().Msg("key is not protected by a password, nothing to remember")
+		return
+	}
+
+	deviceId, err := key.DeviceId()
+	if err != nil {
+		log.Warn().Err(err).Msg("cannot read device id")
+		return
+	}
+
+	err = ctrl.store.Save(deviceId, accessKey)
+	if err != nil {
+		log.Warn().Err(err).Msg("cannot store access key in keyring")
+		return
+	}
+
+	log.Info().Msg("access key stored in keyring")
+}
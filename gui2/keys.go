@@ -0,0 +1,121 @@
+package gui2
+
+import (
+	"fmt"
+
+	"github.com/MeneDev/yubi-oath-vpn/yubikey"
+	"github.com/rs/zerolog/log"
+)
+
+// insertedKey is a YubiKey that is currently inserted, only one of them is used by the dialog at a time
+type insertedKey struct {
+	id           string
+	label        string
+	key          yubikey.YubiKey
+	connectionId string
+	slotName     string
+	// serial is 0 if it cannot be read
+	serial uint32
+}
+
+func insertedKeyNew(number int, key yubikey.YubiKey, connectionId string, slotName string) *insertedKey {
+	id := fmt.Sprintf("key%d", number)
+	label := fmt.Sprintf("YubiKey #%d", number)
+
+	var serial uint32
+	info, err := key.DeviceInfo()
+	if err != nil {
+		log.Debug().Err(err).Msg("cannot read device info for the key label")
+	} else {
+		label = fmt.Sprintf("YubiKey %d (%s)", info.Serial, info.Version)
+		serial = info.Serial
+	}
+
+	return &insertedKey{id: id, label: label, key: key, connectionId: connectionId, slotName: slotName, serial: serial}
+}
+
+func (ctrl *guiController) activateKey(k *insertedKey, event string) {
+	err := ctrl.states.Event(event, k.key, k.connectionId, k.slotName)
+	if err != nil {
+		log.Error().Err(err).Msg("dispatchEvent error")
+	}
+}
+
+func (ctrl *guiController) keyInserted(k *insertedKey) {
+	ctrl.keys = append(ctrl.keys, k)
+	ctrl.updateKeyChooser()
+
+	ctrl.cancelCountdownFor(k)
+
+	if ctrl.states.Can(evKeyInserted) {
+		ctrl.activateKey(k, evKeyInserted)
+		return
+	}
+
+	log.Info().Str("key", k.label).Msg("another YubiKey is in use, the inserted key can be selected in the dialog")
+}
+
+func (ctrl *guiController) keyRemoved(k *insertedKey) {
+	for i, other := range ctrl.keys {
+		if other == k {
+			ctrl.keys = append(ctrl.keys[:i], ctrl.keys[i+1:]...)
+			break
+		}
+	}
+	ctrl.updateKeyChooser()
+
+	// removing a key that is not used by the dialog must not cancel it
+	if k.key != ctrl.yubiKey {
+		log.Debug().Str("key", k.label).Msg("removed YubiKey was not in use")
+		return
+	}
+
+	if !ctrl.states.Can(evKeyRemoved) {
+		return
+	}
+
+	err := ctrl.states.Event(evKeyRemoved)
+	if err != nil {
+		log.Error().Err(err).Msg("dispatchEvent error")
+	}
+
+	ctrl.activateRemainingKey()
+}
+
+// activateRemainingKey continues with one of the remaining keys when the dialog is hidden
+func (ctrl *guiController) activateRemainingKey() {
+	if len(ctrl.keys) > 0 && ctrl.states.Can(evKeyInserted) {
+		ctrl.activateKey(ctrl.keys[0], evKeyInserted)
+	}
+}
+
+func (ctrl *guiController) keySelected(id string) {
+	for _, k := range ctrl.keys {
+		if k.id != id {
+			continue
+		}
+
+		if k.key == ctrl.yubiKey || !ctrl.states.Can(evKeySelected) {
+			return
+		}
+
+		log.Info().Str("key", k.label).Msg("YubiKey selected")
+		ctrl.activateKey(k, evKeySelected)
+		return
+	}
+}
+
+// updateKeyChooser shows the inserted keys so the user can choose among them, it is hidden for a single key
+func (ctrl *guiController) updateKeyChooser() {
+	var ids, labels []string
+	var activeId string
+	for _, k := range ctrl.keys {
+		ids = append(ids, k.id)
+		labels = append(labels, k.label)
+		if k.key == ctrl.yubiKey {
+			activeId = k.id
+		}
+	}
+
+	ctrl.gtkGui.SetKeys(ids, labels, activeId)
+}
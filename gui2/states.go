@@ -3,7 +3,9 @@ package gui2
 import (
 	"context"
 	"errors"
+	"time"
 
+	"github.com/MeneDev/yubi-oath-vpn/oath"
 	"github.com/MeneDev/yubi-oath-vpn/yubierror"
 	"github.com/MeneDev/yubi-oath-vpn/yubikey"
 	"github.com/gotk3/gotk3/glib"
@@ -14,55 +16,91 @@ import (
 const stateHidden = "stateHidden"
 const statePrepare = "statePrepare"
 const stateAskPass = "stateAskPass"
+const stateCalculating = "stateCalculating"
+const stateTouch = "stateTouch"
+const stateSecurityWarning = "stateSecurityWarning"
 const stateConnecting = "stateConnecting"
 const stateConnected = "stateConnected"
+const stateCountdown = "stateCountdown"
 
 const evKeyRemoved = "evKeyRemoved"
 const evKeyInserted = "evKeyInserted"
+const evKeySelected = "evKeySelected"
 const evPasswordRequired = "evPasswordRequired"
 const evPasswordNotRequired = "evPasswordNotRequired"
 const evPasswordEntered = "evPasswordEntered"
 const evWrongPassword = "evWrongPassword"
+const evTouchRequired = "evTouchRequired"
+const evTouchTimeout = "evTouchTimeout"
+const evCodeCalculated = "evCodeCalculated"
+const evCalculationError = "evCalculationError"
+const evVerificationFailed = "evVerificationFailed"
 const evConnectionEstablished = "evConnectionEstablished"
 const evConnectionError = "evConnectionError"
+const evRetryAdjacentWindow = "evRetryAdjacentWindow"
+const evRemovalCountdown = "evRemovalCountdown"
+const evCountdownDone = "evCountdownDone"
 const evCancel = "evCancel"
 const evDone = "evSuccess"
 
+// touchTimeout is a safety net in case the key does not report the missing touch by itself
+const touchTimeout = 20 * time.Second
+
 type eventData struct {
 	event string
 	args  []interface{}
 }
 
+// stateEvents are the transitions of the dialog
+var stateEvents = fsm.Events{
+	{Name: evKeyRemoved, Src: []string{statePrepare, stateAskPass, stateCalculating, stateTouch, stateSecurityWarning}, Dst: stateHidden},
+	{Name: evKeyInserted, Src: []string{stateHidden}, Dst: statePrepare},
+	{Name: evKeySelected, Src: []string{stateAskPass, stateCalculating, stateTouch, stateSecurityWarning}, Dst: statePrepare},
+	{Name: evPasswordRequired, Src: []string{statePrepare}, Dst: stateAskPass},
+	{Name: evPasswordNotRequired, Src: []string{statePrepare}, Dst: stateCalculating},
+	{Name: evPasswordEntered, Src: []string{stateAskPass}, Dst: stateCalculating},
+	{Name: evWrongPassword, Src: []string{stateCalculating}, Dst: stateAskPass},
+	{Name: evTouchRequired, Src: []string{stateCalculating}, Dst: stateTouch},
+	{Name: evTouchTimeout, Src: []string{stateTouch}, Dst: stateAskPass},
+	{Name: evCodeCalculated, Src: []string{stateCalculating, stateTouch}, Dst: stateConnecting},
+	{Name: evCalculationError, Src: []string{stateCalculating, stateTouch}, Dst: stateAskPass},
+	{Name: evVerificationFailed, Src: []string{stateCalculating}, Dst: stateSecurityWarning},
+	{Name: evConnectionEstablished, Src: []string{stateConnecting}, Dst: stateConnected},
+	{Name: evConnectionError, Src: []string{stateConnecting}, Dst: stateAskPass},
+	{Name: evRetryAdjacentWindow, Src: []string{stateConnecting}, Dst: stateCalculating},
+	{Name: evRemovalCountdown, Src: []string{stateHidden, statePrepare, stateAskPass, stateCalculating, stateTouch, stateSecurityWarning, stateConnecting, stateConnected}, Dst: stateCountdown},
+	{Name: evCountdownDone, Src: []string{stateCountdown}, Dst: stateHidden},
+	{Name: evCancel, Src: []string{stateAskPass, stateCalculating, stateTouch, stateSecurityWarning, stateConnecting, stateCountdown}, Dst: stateHidden},
+	{Name: evDone, Src: []string{stateConnected}, Dst: stateHidden},
+}
+
 func (ctrl *guiController) initFsm() {
 
 	states := fsm.NewFSM(
 		stateHidden,
-		fsm.Events{
-			{Name: evKeyRemoved, Src: []string{statePrepare, stateAskPass}, Dst: stateHidden},
-			{Name: evKeyInserted, Src: []string{stateHidden}, Dst: statePrepare},
-			{Name: evPasswordRequired, Src: []string{statePrepare}, Dst: stateAskPass},
-			{Name: evPasswordNotRequired, Src: []string{statePrepare}, Dst: stateConnecting},
-			{Name: evPasswordEntered, Src: []string{stateAskPass}, Dst: stateConnecting},
-			{Name: evWrongPassword, Src: []string{stateConnecting}, Dst: stateAskPass},
-			{Name: evConnectionEstablished, Src: []string{stateConnecting}, Dst: stateConnected},
-			{Name: evConnectionError, Src: []string{stateConnecting}, Dst: stateAskPass},
-			{Name: evCancel, Src: []string{stateAskPass, stateConnecting}, Dst: stateHidden},
-			{Name: evDone, Src: []string{stateConnected}, Dst: stateHidden},
-		},
+		stateEvents,
 		fsm.Callbacks{
 			"enter_state": func(e *fsm.Event) {
 				log.Info().Str("old", e.Src).Str("event", e.Event).Str("new", e.Dst).Msg("transitioning state")
 			},
-			"enter_" + stateHidden:     ctrl.enterHidden,
-			"enter_" + statePrepare:    ctrl.enterPrepare,
-			"enter_" + stateAskPass:    ctrl.enterAskPass,
-			"enter_" + stateConnecting: ctrl.enterConnecting,
-			"enter_" + stateConnected:  ctrl.enterConnected,
-			"leave_" + stateHidden:     ctrl.leaveHidden,
-			"leave_" + statePrepare:    ctrl.leavePrepare,
-			"leave_" + stateAskPass:    ctrl.leaveAskPass,
-			"leave_" + stateConnecting: ctrl.leaveConnecting,
-			"leave_" + stateConnected:  ctrl.leaveConnected,
+			"enter_" + stateHidden:          ctrl.enterHidden,
+			"enter_" + statePrepare:         ctrl.enterPrepare,
+			"enter_" + stateAskPass:         ctrl.enterAskPass,
+			"enter_" + stateCalculating:     ctrl.enterCalculating,
+			"enter_" + stateTouch:           ctrl.enterTouch,
+			"enter_" + stateSecurityWarning: ctrl.enterSecurityWarning,
+			"enter_" + stateConnecting:      ctrl.enterConnecting,
+			"enter_" + stateConnected:       ctrl.enterConnected,
+			"enter_" + stateCountdown:       ctrl.enterCountdown,
+			"leave_" + stateHidden:          ctrl.leaveHidden,
+			"leave_" + statePrepare:         ctrl.leavePrepare,
+			"leave_" + stateAskPass:         ctrl.leaveAskPass,
+			"leave_" + stateCalculating:     ctrl.leaveCalculating,
+			"leave_" + stateTouch:           ctrl.leaveTouch,
+			"leave_" + stateSecurityWarning: ctrl.leaveSecurityWarning,
+			"leave_" + stateConnecting:      ctrl.leaveConnecting,
+			"leave_" + stateConnected:       ctrl.leaveConnected,
+			"leave_" + stateCountdown:       ctrl.leaveCountdown,
 		},
 	)
 
@@ -70,6 +108,7 @@ func (ctrl *guiController) initFsm() {
 }
 
 func (ctrl *guiController) enterHidden(e *fsm.Event) {
+	ctrl.abandonAdjacentWindowRetry()
 	ctrl.gtkGui.reset()
 	ctrl.gtkGui.hide()
 }
@@ -91,16 +130,41 @@ func (ctrl *guiController) enterPrepare(e *fsm.Event) {
 	key := key(e, 0)
 	connectionId := eventString(e, 1)
 	slotName := eventString(e, 2)
-	//key.RequiresPassword()
-	// TODO password required?
 	glib.IdleAdd(func() {
 		ctrl.gtkGui.btnConnect.SetSensitive(true)
 	})
 
+	ctrl.abandonAdjacentWindowRetry()
 	ctrl.yubiKey = key
 	ctrl.connectionId = connectionId
 	ctrl.slotName = slotName
-	ctrl.sendEvent(evPasswordRequired, key, connectionId)
+	ctrl.updateKeyChooser()
+
+	info, err := key.DeviceInfo()
+	if err != nil {
+		log.Warn().Err(err).Msg("cannot read device info")
+		ctrl.gtkGui.SetDeviceInfo("")
+	} else {
+		log.Info().Uint32("serial", info.Serial).Str("version", info.Version.String()).Str("form_factor", info.FormFactor.String()).Msg("YubiKey inserted")
+		ctrl.gtkGui.SetDeviceInfo(info.String())
+	}
+
+	requiresPassword, err := key.RequiresPassword()
+	if err != nil {
+		log.Warn().Err(err).Msg("cannot determine if a password is required, assuming it is")
+		requiresPassword = true
+	}
+
+	if requiresPassword && ctrl.unlockWithStoredKey(key) {
+		requiresPassword = false
+	}
+
+	if requiresPassword {
+		ctrl.sendEvent(evPasswordRequired, key, connectionId)
+	} else {
+		log.Info().Msg("YubiKey is unlocked or not protected by a password")
+		ctrl.sendEvent(evPasswordNotRequired, "")
+	}
 }
 func (ctrl *guiController) leavePrepare(e *fsm.Event) {
 
@@ -109,6 +173,7 @@ func (ctrl *guiController) leavePrepare(e *fsm.Event) {
 func (ctrl *guiController) enterAskPass(e *fsm.Event) {
 	args := e.Args
 	log.Debug().Interface("args", args).Msg("enterAskPass")
+	ctrl.abandonAdjacentWindowRetry()
 	ctrl.gtkGui.reset()
 	if e.Event == evWrongPassword {
 		ctrl.gtkGui.SetError(yubierror.ErrorWrongPassword)
@@ -123,43 +188,173 @@ func (ctrl *guiController) enterAskPass(e *fsm.Event) {
 			ctrl.gtkGui.SetError(errors.New("unknown Error"))
 		}
 	}
+	if e.Event == evTouchTimeout {
+		ctrl.gtkGui.SetError(yubierror.ErrorTouchTimeout)
+	}
+	if e.Event == evCalculationError {
+		err := args[0].(error)
+		log.Debug().Err(err).Msg("setting GTK error message")
+		ctrl.gtkGui.SetError(err)
+	}
 
+	// after a failed connection the key is usually still unlocked, connecting again continues with an empty password
+	ctrl.gtkGui.SetPasswordRequired(ctrl.passwordRequired(e.Event))
 	ctrl.gtkGui.show()
 	// e.Args contains error to show?
 }
 
 func (ctrl *guiController) leaveAskPass(e *fsm.Event) {
+	ctrl.gtkGui.SetPasswordRequired(true)
 }
 
-func (ctrl *guiController) enterConnecting(e *fsm.Event) {
+// passwordRequired reports if the password has to be entered, it is only asked again when the cached access key was
+// rejected, e.g. because the card was reset
+func (ctrl *guiController) passwordRequired(event string) bool {
+	if event == evPasswordRequired || event == evWrongPassword {
+		return true
+	}
+
+	required, err := ctrl.yubiKey.RequiresPassword()
+	if err != nil {
+		log.Warn().Err(err).Msg("cannot determine if a password is required, assuming it is")
+		return true
+	}
+	return required
+}
+
+func (ctrl *guiController) enterCalculating(e *fsm.Event) {
+	message := "Reading code..."
+	if e.Event == evRetryAdjacentWindow {
+		message = "Code was rejected, trying the adjacent time step..."
+	}
+
 	glib.IdleAdd(func() {
 		ctrl.gtkGui.boxConnecting.SetVisible(true)
 		ctrl.gtkGui.spnConnecting.Start()
-		ctrl.gtkGui.lblConnect.SetLabel("Connecting...")
+		ctrl.gtkGui.lblConnect.SetLabel(message)
 		ctrl.gtkGui.btnConnect.SetSensitive(false)
 	})
 
 	ctrl.gtkGui.HideError()
 
 	password := e.Args[0].(string)
-	code, err := ctrl.yubiKey.GetCodeWithPassword(password, ctrl.slotName)
+	remember := e.Event == evPasswordEntered && len(e.Args) > 1 && e.Args[1].(bool)
+	ctx, cancel := context.WithCancel(ctrl.ctx)
+	ctrl.cancelCurrentCalculation = cancel
 
-	if err != nil {
-		log.Error().Err(err).Msg("error getting code from yubikey")
-		if err == yubierror.ErrorWrongPassword {
-			ctrl.sendEvent(evWrongPassword)
+	key := ctrl.yubiKey
+	slotName := ctrl.slotName
+	rejected := ctrl.adjacentWindowRetry.rejectedCodes()
+
+	// the key blocks while waiting for a touch, so the code is calculated outside the event loop
+	go func() {
+		touchAnnounced := false
+		code, err := ctrl.calculateCode(ctx, key, password, slotName, rejected, func() {
+			if ctx.Err() == nil && !touchAnnounced {
+				touchAnnounced = true
+				ctrl.sendEvent(evTouchRequired)
+			}
+		})
+
+		if ctx.Err() != nil {
+			log.Debug().Msg("calculation canceled, dropping result")
+			return
+		}
+
+		if err != nil {
+			log.Error().Err(err).Msg("error getting code from yubikey")
+			if errors.Is(err, yubierror.ErrorWrongPassword) {
+				ctrl.sendEvent(evWrongPassword)
+			} else if errors.Is(err, yubierror.ErrorVerificationFailed) {
+				ctrl.sendEvent(evVerificationFailed, err)
+			} else {
+				ctrl.sendEvent(evCalculationError, err)
+			}
+			return
+		}
+
+		if remember {
+			ctrl.rememberAccessKey(key)
 		}
 
-		// TODO: other cases
+		log.Debug().Str("code", code.Value).Msg("code from yubikey")
+		ctrl.sendEvent(evCodeCalculated, code)
+	}()
+}
+
+func (ctrl *guiController) leaveCalculating(e *fsm.Event) {
+	// the calculation continues when the user has to touch the key
+	if e.Dst == stateTouch {
 		return
 	}
 
-	log.Debug().Str("code", code).Msg("code from yubikey")
+	ctrl.stopCalculation()
+}
+
+func (ctrl *guiController) enterTouch(e *fsm.Event) {
+	glib.IdleAdd(func() {
+		ctrl.gtkGui.boxConnecting.SetVisible(true)
+		ctrl.gtkGui.spnConnecting.Start()
+		ctrl.gtkGui.lblConnect.SetLabel("Touch your YubiKey...")
+	})
+
+	ctrl.gtkGui.show()
+
+	ctrl.touchTimer = time.AfterFunc(touchTimeout, func() {
+		ctrl.sendEvent(evTouchTimeout)
+	})
+}
+
+func (ctrl *guiController) leaveTouch(e *fsm.Event) {
+	if ctrl.touchTimer != nil {
+		ctrl.touchTimer.Stop()
+		ctrl.touchTimer = nil
+	}
+
+	ctrl.stopCalculation()
+}
+
+func (ctrl *guiController) enterSecurityWarning(e *fsm.Event) {
+	err := e.Args[0].(error)
+	log.Warn().Err(err).Msg("security event: refusing to connect with this YubiKey")
+
+	ctrl.gtkGui.reset()
+	glib.IdleAdd(func() {
+		ctrl.gtkGui.btnConnect.SetSensitive(false)
+		ctrl.gtkGui.txtPassword.SetSensitive(false)
+	})
+	ctrl.gtkGui.SetError(err)
+	ctrl.gtkGui.show()
+}
+
+func (ctrl *guiController) leaveSecurityWarning(e *fsm.Event) {
+	glib.IdleAdd(func() {
+		ctrl.gtkGui.txtPassword.SetSensitive(true)
+	})
+}
+
+func (ctrl *guiController) stopCalculation() {
+	if ctrl.cancelCurrentCalculation != nil {
+		ctrl.cancelCurrentCalculation()
+		ctrl.cancelCurrentCalculation = nil
+	}
+}
+
+func (ctrl *guiController) enterConnecting(e *fsm.Event) {
+	glib.IdleAdd(func() {
+		ctrl.gtkGui.boxConnecting.SetVisible(true)
+		ctrl.gtkGui.spnConnecting.Start()
+		ctrl.gtkGui.lblConnect.SetLabel("Connecting...")
+		ctrl.gtkGui.btnConnect.SetSensitive(false)
+	})
+
+	code := e.Args[0].(oath.Code)
+	ctrl.markSubmitted(code)
 
 	ctx, cancel := context.WithCancel(context.Background())
 
 	ctrl.cancelCurrentConnection = cancel
-	ctrl.initializeConnectionChan <- ConnectionParameters{Context: ctx, ConnectionId: ctrl.connectionId, Code: code}
+	ctrl.initializeConnectionChan <- ConnectionParameters{Context: ctx, ConnectionId: ctrl.connectionId, Code: code.Value}
 }
 
 func (ctrl *guiController) leaveConnecting(e *fsm.Event) {
@@ -176,6 +371,7 @@ func (ctrl *guiController) leaveConnecting(e *fsm.Event) {
 }
 
 func (ctrl *guiController) enterConnected(e *fsm.Event) {
+	ctrl.acceptAdjacentWindowRetry()
 	// TODO show indicator
 	ctrl.sendEvent(evDone)
 }
@@ -0,0 +1,17 @@
+package keyring
+
+import "errors"
+
+// ErrorNotStored is returned when no access key is stored for a YubiKey
+var ErrorNotStored = errors.New("no access key stored for this YubiKey")
+
+// Store keeps the access keys derived from the OATH passwords of YubiKeys, never the passwords themselves.
+// The keys are looked up by the device id of the OATH applet, which changes when the applet is reset.
+type Store interface {
+	// Load returns the stored access key or ErrorNotStored
+	Load(deviceId []byte) ([]byte, error)
+	Save(deviceId []byte, accessKey []byte) error
+	Delete(deviceId []byte) error
+	// DeleteAll deletes the access keys of all YubiKeys
+	DeleteAll() error
+}
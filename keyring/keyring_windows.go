@@ -0,0 +1,6 @@
+package keyring
+
+// DefaultStore returns the store of the platform, nil if there is none
+func DefaultStore() Store {
+	return nil
+}
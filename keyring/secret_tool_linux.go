@@ -0,0 +1,92 @@
+package keyring
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+const secretToolApplication = "yubi-oath-vpn"
+
+// DefaultStore returns the store of the platform, nil if there is none
+func DefaultStore() Store {
+	_, err := exec.LookPath("secret-tool")
+	if err != nil {
+		log.Info().Err(err).Msg("secret-tool not found, passwords cannot be remembered")
+		return nil
+	}
+
+	return SecretToolStoreNew()
+}
+
+var _ Store = (*secretToolStore)(nil)
+
+// secretToolStore keeps the access keys in the freedesktop Secret Service (e.g. GNOME Keyring or KWallet) via
+// secret-tool, the command line interface of libsecret
+type secretToolStore struct {
+	command string
+}
+
+func SecretToolStoreNew() Store {
+	return &secretToolStore{command: "secret-tool"}
+}
+
+func (s *secretToolStore) Load(deviceId []byte) ([]byte, error) {
+	out, err := s.run("", "lookup", "application", secretToolApplication, "device-id", hex.EncodeToString(deviceId))
+	// secret-tool exits with status 1 and prints nothing if there is no matching secret
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && out == "" {
+		return nil, ErrorNotStored
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	accessKey, err := hex.DecodeString(strings.TrimSpace(out))
+	if err != nil {
+		return nil, fmt.Errorf("malformed access key in keyring: %w", err)
+	}
+	return accessKey, nil
+}
+
+func (s *secretToolStore) Save(deviceId []byte, accessKey []byte) error {
+	id := hex.EncodeToString(deviceId)
+	label := fmt.Sprintf("--label=YubiKey OATH access key %s", id)
+
+	_, err := s.run(hex.EncodeToString(accessKey), "store", label, "application", secretToolApplication, "device-id", id)
+	return err
+}
+
+func (s *secretToolStore) Delete(deviceId []byte) error {
+	_, err := s.run("", "clear", "application", secretToolApplication, "device-id", hex.EncodeToString(deviceId))
+	return err
+}
+
+func (s *secretToolStore) DeleteAll() error {
+	_, err := s.run("", "clear", "application", secretToolApplication)
+	return err
+}
+
+// run runs secret-tool, secrets are passed via stdin so they do not show up in the process list
+func (s *secretToolStore) run(stdin string, args ...string) (string, error) {
+	cmd := exec.Command(s.command, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+
+	stdout := bytes.NewBuffer(nil)
+	stderr := bytes.NewBuffer(nil)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	log.Debug().Str("command", args[0]).Msg("running secret-tool")
+	err := cmd.Run()
+	if err != nil && stderr.Len() > 0 {
+		return stdout.String(), fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), err
+}
@@ -0,0 +1,48 @@
+package keyring
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSecretTool keeps one secret per device id in a directory, like secret-tool it reads secrets from stdin.
+// A missing secret also writes to stderr, as secret-tool does when the secret service logs a warning.
+const fakeSecretTool = `#!/bin/sh
+dir=$(dirname "$0")/secrets
+mkdir -p "$dir"
+case "$1" in
+store) cat > "$dir/$6" ;;
+lookup) cat "$dir/$5" 2>/dev/null || { echo "warning from the secret service" >&2; exit 1; } ;;
+clear) if [ -n "$5" ]; then rm -f "$dir/$5"; else rm -f "$dir"/*; fi ;;
+esac
+`
+
+func TestSecretToolStore(t *testing.T) {
+	command := filepath.Join(t.TempDir(), "secret-tool")
+	assert.NoError(t, os.WriteFile(command, []byte(fakeSecretTool), 0700))
+	store := &secretToolStore{command: command}
+
+	deviceId := []byte{0x5b, 0x1c, 0xcc, 0x20, 0xd4, 0xab, 0x2f, 0xdf}
+	accessKey := []byte{0x01, 0x02, 0x03, 0x04}
+
+	_, err := store.Load(deviceId)
+	assert.Equal(t, ErrorNotStored, err)
+
+	assert.NoError(t, store.Save(deviceId, accessKey))
+	assert.NoError(t, store.Save([]byte{0x01}, accessKey))
+
+	loaded, err := store.Load(deviceId)
+	assert.NoError(t, err)
+	assert.Equal(t, accessKey, loaded)
+
+	assert.NoError(t, store.Delete(deviceId))
+	_, err = store.Load(deviceId)
+	assert.Equal(t, ErrorNotStored, err)
+
+	assert.NoError(t, store.DeleteAll())
+	_, err = store.Load([]byte{0x01})
+	assert.Equal(t, ErrorNotStored, err)
+}
@@ -7,24 +7,33 @@ import (
 type ConnectionAttemptResult interface {
 	String() string
 	Success() bool
+	// AuthFailed reports a failed attempt because the server rejected the credentials, e.g. the code
+	AuthFailed() bool
 }
 
 type NetworkController interface {
 	Connect(ctx context.Context, connectionName string, code string)
+	// Disconnect closes the connection and waits until it is closed
+	Disconnect(ctx context.Context, connectionName string) error
 	ConnectionResults() <-chan ConnectionAttemptResult
 }
 
 var _ ConnectionAttemptResult = (*nmcliResult)(nil)
 
 type nmcliResult struct {
-	message string
-	success bool
+	message    string
+	success    bool
+	authFailed bool
 }
 
 func (r *nmcliResult) Success() bool {
 	return r.success
 }
 
+func (r *nmcliResult) AuthFailed() bool {
+	return r.authFailed
+}
+
 func (r *nmcliResult) String() string {
 	return r.message
 }
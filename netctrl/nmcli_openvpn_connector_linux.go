@@ -3,8 +3,11 @@ package netctrl
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"strings"
 
 	"github.com/rs/zerolog/log"
 )
@@ -45,6 +48,8 @@ type nmcliOpenVpnConnector struct {
 func (ctor *nmcliOpenVpnConnector) Connect(ctx context.Context, connectionName string, code string) {
 	go func() {
 		subProcess := exec.CommandContext(ctx, "nmcli", "con", "up", connectionName, "passwd-file", "/dev/fd/0")
+		// the messages are matched to detect a rejected login
+		subProcess.Env = append(os.Environ(), "LC_ALL=C")
 		stdin, err := subProcess.StdinPipe()
 		if err != nil {
 			return
@@ -69,13 +74,42 @@ func (ctor *nmcliOpenVpnConnector) Connect(ctx context.Context, connectionName s
 
 		stderrStr := stderr.String()
 		if stderrStr != "" {
-			ctor.resultsChan <- &nmcliResult{message: stderrStr, success: false}
+			ctor.resultsChan <- &nmcliResult{message: stderrStr, success: false, authFailed: nmcliAuthFailed(stderrStr)}
 		} else {
 			ctor.resultsChan <- &nmcliResult{message: "Done", success: true}
 		}
 	}()
 }
 
+// nmcliLoginFailedReasons are the untranslated reasons nmcli prints when the VPN plugin reports a failed login,
+// NetworkManager-openvpn reports the AUTH_FAILED of the server as such
+var nmcliLoginFailedReasons = []string{
+	// NM_ACTIVE_CONNECTION_STATE_REASON_LOGIN_FAILED
+	"Connection activation failed: Invalid secrets",
+	// NM_VPN_CONNECTION_STATE_REASON_LOGIN_FAILED, printed by older versions
+	"Connection activation failed: invalid VPN secrets",
+}
+
+// nmcliAuthFailed reports if the output of nmcli with LC_ALL=C is a rejected login
+func nmcliAuthFailed(stderr string) bool {
+	for _, reason := range nmcliLoginFailedReasons {
+		if strings.Contains(stderr, reason) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ctor *nmcliOpenVpnConnector) Disconnect(ctx context.Context, connectionName string) error {
+	log.Debug().Str("connection", connectionName).Msg("disconnecting via nmcli")
+	output, err := exec.CommandContext(ctx, "nmcli", "con", "down", connectionName).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
 func (ctor *nmcliOpenVpnConnector) ConnectionResults() <-chan ConnectionAttemptResult {
 	return ctor.resultsChan
 }
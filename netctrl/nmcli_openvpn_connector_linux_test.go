@@ -0,0 +1,29 @@
+package netctrl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNmcliAuthFailed(t *testing.T) {
+	tests := []struct {
+		name   string
+		stderr string
+		want   bool
+	}{
+		{"invalid secrets", "Error: Connection activation failed: Invalid secrets\nHint: use 'journalctl -xe NM_CONNECTION=5c9a3d1e-0f5a-4b5e-9d7e-2f6c1b8a4e31 + NM_DEVICE=wlp2s0' to get more details.\n", true},
+		{"invalid VPN secrets", "Error: Connection activation failed: invalid VPN secrets\n", true},
+		{"no secrets", "Error: Connection activation failed: No valid secrets\nHint: use 'journalctl -xe NM_CONNECTION=5c9a3d1e-0f5a-4b5e-9d7e-2f6c1b8a4e31 + NM_DEVICE=wlp2s0' to get more details.\n", false},
+		{"service stopped", "Error: Connection activation failed: The VPN service stopped unexpectedly\nHint: use 'journalctl -xe NM_CONNECTION=5c9a3d1e-0f5a-4b5e-9d7e-2f6c1b8a4e31 + NM_DEVICE=wlp2s0' to get more details.\n", false},
+		{"timeout", "Error: Connection activation failed: The connection attempt timed out\n", false},
+		{"unknown connection", "Error: unknown connection 'work'.\n", false},
+		{"nmcli timeout", "Error: Timeout expired (90 seconds)\n", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, nmcliAuthFailed(test.stderr))
+		})
+	}
+}
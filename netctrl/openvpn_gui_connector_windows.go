@@ -135,6 +135,7 @@ func (ctor *openVpnGuiConnector) Connect(ctx context.Context, connectionName str
 		followLogFile(followContext, logPath, linesChan)
 
 		hasError := false
+		authFailed := false
 
 		loglines := make([]string, 0)
 
@@ -161,7 +162,10 @@ func (ctor *openVpnGuiConnector) Connect(ctx context.Context, connectionName str
 
 				loglines = append(loglines, line)
 
-				if strings.Contains(line, "Restart pause") || strings.Contains(line, "AUTH_FAILED") || strings.Contains(line, "ERROR") {
+				if strings.Contains(line, "AUTH_FAILED") {
+					authFailed = true
+				}
+				if strings.Contains(line, "Restart pause") || authFailed || strings.Contains(line, "ERROR") {
 					log.Warn().Str("line", line).Msg("found error")
 					hasError = true
 					connecting = false
@@ -193,10 +197,28 @@ func (ctor *openVpnGuiConnector) Connect(ctx context.Context, connectionName str
 			log.Info().Msg("sending disconnect: done")
 		}
 
-		ctor.resultsChan <- &nmcliResult{message: strings.Join(loglines, "\n"), success: false}
+		ctor.resultsChan <- &nmcliResult{message: strings.Join(loglines, "\n"), success: false, authFailed: authFailed}
 	}()
 }
 
+func (ctor *openVpnGuiConnector) Disconnect(ctx context.Context, connectionName string) error {
+	reg, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\OpenVPN`, registry.QUERY_VALUE)
+	if err != nil {
+		return errors.Wrap(err, "error opening registry key")
+	}
+	defer reg.Close()
+
+	exePath, _, err := reg.GetStringValue(`exe_path`)
+	if err != nil {
+		return errors.Wrap(err, "error reading exe_path")
+	}
+
+	exe := filepath.Join(filepath.Dir(exePath), "openvpn-gui.exe")
+
+	log.Debug().Str("connection", connectionName).Msg("disconnecting via openvpn-gui")
+	return execute(ctx, exe, "--command", "disconnect", connectionName)
+}
+
 func storePassword(connectionName string, code string) {
 	k, err := registry.OpenKey(registry.CURRENT_USER, `SOFTWARE\OpenVPN-GUI\configs\`+connectionName, registry.QUERY_VALUE|registry.WRITE)
 	if err != nil {
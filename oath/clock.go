@@ -0,0 +1,57 @@
+package oath
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides the time TOTP codes are calculated for
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the clock of the operating system
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// FixedClock always returns the same time
+type FixedClock struct {
+	Time time.Time
+}
+
+func (c FixedClock) Now() time.Time {
+	return c.Time
+}
+
+// OffsetClock shifts another clock by an offset, compensating a clock that is off.
+// The offset can be changed while the clock is in use.
+type OffsetClock struct {
+	clock  Clock
+	mu     sync.Mutex
+	offset time.Duration
+}
+
+func OffsetClockNew(clock Clock, offset time.Duration) *OffsetClock {
+	return &OffsetClock{clock: clock, offset: offset}
+}
+
+func (c *OffsetClock) Now() time.Time {
+	return c.clock.Now().Add(c.Offset())
+}
+
+func (c *OffsetClock) Offset() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.offset
+}
+
+func (c *OffsetClock) SetOffset(offset time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.offset = offset
+}
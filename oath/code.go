@@ -0,0 +1,91 @@
+package oath
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultPeriod is the time step in seconds used by TOTP credentials without a period prefix
+const DefaultPeriod = 30
+
+// TimeChallenge returns the challenge for the time step containing t
+func TimeChallenge(t time.Time, period int) []byte {
+	if period <= 0 {
+		period = DefaultPeriod
+	}
+
+	challenge := make([]byte, 8)
+	binary.BigEndian.PutUint64(challenge, uint64(t.UTC().Unix()/int64(period)))
+	return challenge
+}
+
+// Code is a calculated code, TOTP codes are only valid within their time step
+type Code struct {
+	// Name is the name of the credential the code was calculated for
+	Name  string
+	Value string
+	// Period is the length of the time step in seconds, it is 0 for HOTP codes which do not expire
+	Period int
+	// Step is the time step the code was calculated for
+	Step       uint64
+	ValidFrom  time.Time
+	ValidUntil time.Time
+}
+
+// TotpCode returns the code of the credential calculated for the time step containing t
+func TotpCode(name string, value string, t time.Time, period int) Code {
+	if period <= 0 {
+		period = DefaultPeriod
+	}
+
+	step := uint64(t.UTC().Unix() / int64(period))
+	validFrom := time.Unix(int64(step)*int64(period), 0)
+
+	return Code{
+		Name:       name,
+		Value:      value,
+		Period:     period,
+		Step:       step,
+		ValidFrom:  validFrom,
+		ValidUntil: validFrom.Add(time.Duration(period) * time.Second),
+	}
+}
+
+// Expires reports if the code is only valid for a time step
+func (c Code) Expires() bool {
+	return c.Period > 0
+}
+
+// Remaining returns how long the code stays valid after t
+func (c Code) Remaining(t time.Time) time.Duration {
+	if !c.Expires() {
+		return 0
+	}
+	if t.After(c.ValidUntil) {
+		return 0
+	}
+	return c.ValidUntil.Sub(t)
+}
+
+// FormatCode formats the value of a truncated response, its first byte holds the number of digits
+func FormatCode(truncated []byte) (string, error) {
+	if len(truncated) != 5 {
+		return "", errors.New("malformed truncated response")
+	}
+
+	digits := int(truncated[0])
+	if digits < 6 || digits > 10 {
+		return "", fmt.Errorf("unsupported number of digits: %d", digits)
+	}
+
+	code := uint64(binary.BigEndian.Uint32(truncated[1:]) & 0x7fffffff)
+
+	modulo := uint64(1)
+	for i := 0; i < digits; i++ {
+		modulo *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, code%modulo), nil
+}
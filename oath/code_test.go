@@ -0,0 +1,50 @@
+package oath
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeChallenge(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+
+	assert.Equal(t, []byte{0, 0, 0, 0, 0x02, 0x35, 0x23, 0xEC}, TimeChallenge(now, 30))
+	assert.Equal(t, []byte{0, 0, 0, 0, 0x01, 0x1A, 0x91, 0xF6}, TimeChallenge(now, 60))
+}
+
+func TestFormatCode(t *testing.T) {
+	t.Run("six digits", func(t *testing.T) {
+		code, err := FormatCode([]byte{6, 0x00, 0x00, 0x30, 0x39})
+
+		assert.NoError(t, err)
+		assert.Equal(t, "012345", code)
+	})
+
+	t.Run("eight digits", func(t *testing.T) {
+		code, err := FormatCode([]byte{8, 0x7f, 0xff, 0xff, 0xff})
+
+		assert.NoError(t, err)
+		assert.Equal(t, "47483647", code)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		_, err := FormatCode([]byte{6, 0x00})
+
+		assert.Error(t, err)
+	})
+}
+
+func TestTotpCode(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+
+	code := TotpCode("vpn", "123456", now, 30)
+
+	assert.Equal(t, uint64(37037036), code.Step)
+	assert.Equal(t, time.Unix(1111111080, 0), code.ValidFrom)
+	assert.Equal(t, time.Unix(1111111110, 0), code.ValidUntil)
+	assert.Equal(t, time.Second, code.Remaining(now))
+	assert.Equal(t, time.Duration(0), code.Remaining(now.Add(time.Minute)))
+	assert.False(t, Code{Value: "123456"}.Expires())
+}
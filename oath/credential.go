@@ -0,0 +1,79 @@
+package oath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type Type byte
+
+const (
+	HOTP Type = 0x10
+	TOTP Type = 0x20
+)
+
+func (t Type) String() string {
+	switch t {
+	case HOTP:
+		return "HOTP"
+	case TOTP:
+		return "TOTP"
+	}
+	return fmt.Sprintf("unknown type 0x%02x", byte(t))
+}
+
+type Algorithm byte
+
+const (
+	SHA1   Algorithm = 0x01
+	SHA256 Algorithm = 0x02
+	SHA512 Algorithm = 0x03
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case SHA1:
+		return "SHA1"
+	case SHA256:
+		return "SHA256"
+	case SHA512:
+		return "SHA512"
+	}
+	return fmt.Sprintf("unknown algorithm 0x%02x", byte(a))
+}
+
+// Credential describes an OATH account stored on the key
+type Credential struct {
+	// Name is the raw name as stored on the key, used to address the credential
+	Name      string
+	Issuer    string
+	Account   string
+	Type      Type
+	Algorithm Algorithm
+	// Period is the length of a time step in seconds, it is 0 for HOTP credentials
+	Period        int
+	RequiresTouch bool
+}
+
+// ParseCredentialName splits the name of a credential into period, issuer and account.
+// The name has the form [period/][issuer:]account, the period defaults to DefaultPeriod.
+func ParseCredentialName(name string) (period int, issuer string, account string) {
+	period = DefaultPeriod
+	if idx := strings.Index(name, "/"); idx > 0 {
+		if p, err := strconv.Atoi(name[:idx]); err == nil && p > 0 {
+			period = p
+			name = name[idx+1:]
+		}
+	}
+
+	if idx := strings.Index(name, ":"); idx > 0 {
+		return period, name[:idx], name[idx+1:]
+	}
+	return period, "", name
+}
+
+// ParseTypeAndAlgorithm decodes the combined type/algorithm byte used in LIST responses
+func ParseTypeAndAlgorithm(b byte) (Type, Algorithm) {
+	return Type(b & 0xf0), Algorithm(b & 0x0f)
+}
@@ -0,0 +1,40 @@
+package oath
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCredentialName(t *testing.T) {
+	t.Run("issuer and account", func(t *testing.T) {
+		period, issuer, account := ParseCredentialName("Example:user@example.com")
+
+		assert.Equal(t, DefaultPeriod, period)
+		assert.Equal(t, "Example", issuer)
+		assert.Equal(t, "user@example.com", account)
+	})
+
+	t.Run("account only", func(t *testing.T) {
+		period, issuer, account := ParseCredentialName("user@example.com")
+
+		assert.Equal(t, DefaultPeriod, period)
+		assert.Equal(t, "", issuer)
+		assert.Equal(t, "user@example.com", account)
+	})
+
+	t.Run("period prefix", func(t *testing.T) {
+		period, issuer, account := ParseCredentialName("60/Example:user@example.com")
+
+		assert.Equal(t, 60, period)
+		assert.Equal(t, "Example", issuer)
+		assert.Equal(t, "user@example.com", account)
+	})
+}
+
+func TestParseTypeAndAlgorithm(t *testing.T) {
+	oathType, algorithm := ParseTypeAndAlgorithm(0x22)
+
+	assert.Equal(t, TOTP, oathType)
+	assert.Equal(t, SHA256, algorithm)
+}
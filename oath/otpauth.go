@@ -0,0 +1,168 @@
+package oath
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// MaxNameLength is the maximum length of a credential name accepted by the key
+const MaxNameLength = 64
+
+// minKeyLength is the minimum length of a secret, shorter secrets are padded with zeros
+const minKeyLength = 14
+
+// CredentialData holds everything needed to store a new credential on the key
+type CredentialData struct {
+	Issuer        string
+	Account       string
+	Type          Type
+	Algorithm     Algorithm
+	Digits        int
+	Period        int
+	Counter       uint32
+	Secret        []byte
+	RequiresTouch bool
+}
+
+// ParseUri parses an otpauth:// URI as used in QR codes for authenticator apps
+func ParseUri(uri string) (CredentialData, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return CredentialData{}, err
+	}
+
+	if u.Scheme != "otpauth" {
+		return CredentialData{}, fmt.Errorf("unsupported scheme %q, expected otpauth", u.Scheme)
+	}
+
+	data := CredentialData{
+		Algorithm: SHA1,
+		Digits:    6,
+		Period:    DefaultPeriod,
+	}
+
+	switch strings.ToLower(u.Host) {
+	case "totp":
+		data.Type = TOTP
+	case "hotp":
+		data.Type = HOTP
+	default:
+		return CredentialData{}, fmt.Errorf("unsupported OATH type %q", u.Host)
+	}
+
+	label := strings.TrimPrefix(u.Path, "/")
+	if idx := strings.Index(label, ":"); idx > 0 {
+		data.Issuer = strings.TrimSpace(label[:idx])
+		data.Account = strings.TrimSpace(label[idx+1:])
+	} else {
+		data.Account = label
+	}
+
+	query := u.Query()
+
+	if issuer := query.Get("issuer"); issuer != "" {
+		data.Issuer = issuer
+	}
+
+	secret := strings.ToUpper(strings.ReplaceAll(query.Get("secret"), " ", ""))
+	secret = strings.TrimRight(secret, "=")
+	data.Secret, err = base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return CredentialData{}, fmt.Errorf("invalid secret: %w", err)
+	}
+	if len(data.Secret) == 0 {
+		return CredentialData{}, errors.New("missing secret")
+	}
+
+	if algorithm := query.Get("algorithm"); algorithm != "" {
+		switch strings.ToUpper(algorithm) {
+		case "SHA1":
+			data.Algorithm = SHA1
+		case "SHA256":
+			data.Algorithm = SHA256
+		case "SHA512":
+			data.Algorithm = SHA512
+		default:
+			return CredentialData{}, fmt.Errorf("unsupported algorithm %q", algorithm)
+		}
+	}
+
+	if digits := query.Get("digits"); digits != "" {
+		data.Digits, err = strconv.Atoi(digits)
+		if err != nil || data.Digits < 6 || data.Digits > 8 {
+			return CredentialData{}, fmt.Errorf("unsupported number of digits %q", digits)
+		}
+	}
+
+	if period := query.Get("period"); period != "" {
+		data.Period, err = strconv.Atoi(period)
+		if err != nil || data.Period <= 0 {
+			return CredentialData{}, fmt.Errorf("invalid period %q", period)
+		}
+	}
+
+	if counter := query.Get("counter"); counter != "" {
+		c, err := strconv.ParseUint(counter, 10, 32)
+		if err != nil {
+			return CredentialData{}, fmt.Errorf("invalid counter %q", counter)
+		}
+		data.Counter = uint32(c)
+	}
+
+	if len(data.Name()) > MaxNameLength {
+		return CredentialData{}, fmt.Errorf("credential name %q is longer than %d bytes", data.Name(), MaxNameLength)
+	}
+
+	return data, nil
+}
+
+// Name returns the name the credential is stored under on the key
+func (d CredentialData) Name() string {
+	name := d.Account
+	if d.Issuer != "" {
+		name = d.Issuer + ":" + name
+	}
+	if d.Type == TOTP && d.Period != DefaultPeriod {
+		name = fmt.Sprintf("%d/%s", d.Period, name)
+	}
+	return name
+}
+
+// Key returns the value of the key TLV used to store the credential
+func (d CredentialData) Key() []byte {
+	secret := d.Secret
+
+	// HMAC hashes keys longer than the block size, the key only accepts the hashed form
+	switch d.Algorithm {
+	case SHA1:
+		if len(secret) > sha1.BlockSize {
+			sum := sha1.Sum(secret)
+			secret = sum[:]
+		}
+	case SHA256:
+		if len(secret) > sha256.BlockSize {
+			sum := sha256.Sum256(secret)
+			secret = sum[:]
+		}
+	case SHA512:
+		if len(secret) > sha512.BlockSize {
+			sum := sha512.Sum512(secret)
+			secret = sum[:]
+		}
+	}
+
+	key := []byte{byte(d.Type) | byte(d.Algorithm), byte(d.Digits)}
+	key = append(key, secret...)
+	for i := len(secret); i < minKeyLength; i++ {
+		key = append(key, 0)
+	}
+
+	return key
+}
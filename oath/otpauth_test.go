@@ -0,0 +1,52 @@
+package oath
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUri(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		data, err := ParseUri("otpauth://totp/Example:alice@example.com?secret=JBSWY3DPEHPK3PXP&issuer=Example")
+
+		assert.NoError(t, err)
+		assert.Equal(t, TOTP, data.Type)
+		assert.Equal(t, SHA1, data.Algorithm)
+		assert.Equal(t, 6, data.Digits)
+		assert.Equal(t, DefaultPeriod, data.Period)
+		assert.Equal(t, "Example:alice@example.com", data.Name())
+		assert.Equal(t, []byte("Hello!\xde\xad\xbe\xef"), data.Secret)
+	})
+
+	t.Run("custom parameters", func(t *testing.T) {
+		data, err := ParseUri("otpauth://totp/alice@example.com?secret=jbswy3dpehpk3pxp&algorithm=SHA256&digits=8&period=60")
+
+		assert.NoError(t, err)
+		assert.Equal(t, SHA256, data.Algorithm)
+		assert.Equal(t, 8, data.Digits)
+		assert.Equal(t, "60/alice@example.com", data.Name())
+	})
+
+	t.Run("hotp", func(t *testing.T) {
+		data, err := ParseUri("otpauth://hotp/alice@example.com?secret=JBSWY3DPEHPK3PXP&counter=5")
+
+		assert.NoError(t, err)
+		assert.Equal(t, HOTP, data.Type)
+		assert.Equal(t, uint32(5), data.Counter)
+	})
+
+	t.Run("missing secret", func(t *testing.T) {
+		_, err := ParseUri("otpauth://totp/alice@example.com")
+
+		assert.Error(t, err)
+	})
+}
+
+func TestCredentialData_Key(t *testing.T) {
+	data := CredentialData{Type: TOTP, Algorithm: SHA1, Digits: 6, Secret: []byte{1, 2, 3}}
+
+	key := data.Key()
+
+	assert.Equal(t, []byte{0x21, 6, 1, 2, 3, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, key)
+}
@@ -0,0 +1,47 @@
+package oath
+
+type AID []byte
+
+var AID_OTP = AID{0xA0, 0x00, 0x00, 0x05, 0x27, 0x20, 0x01}
+var AID_OATH = AID{0xa0, 0x00, 0x00, 0x05, 0x27, 0x21, 0x01}
+var AID_MGR = AID{0xa0, 0x00, 0x00, 0x05, 0x27, 0x47, 0x11, 0x17}
+
+type INS byte
+
+const (
+	PUT            INS = 0x01
+	DELETE         INS = 0x02
+	SET_CODE       INS = 0x03
+	RESET          INS = 0x04
+	RENAME         INS = 0x05
+	LIST           INS = 0xa1
+	CALCULATE      INS = 0xa2
+	VALIDATE       INS = 0xa3
+	CALCULATE_ALL  INS = 0xa4
+	SEND_REMAINING INS = 0xa5
+)
+
+const GP_INS_SELECT byte = 0xA4
+
+const (
+	OATH_TAG_NAME               byte = 0x71
+	OATH_TAG_NAME_LIST          byte = 0x72
+	OATH_TAG_KEY                byte = 0x73
+	OATH_TAG_CHALLENGE          byte = 0x74
+	OATH_TAG_RESPONSE           byte = 0x75
+	OATH_TAG_TRUNCATED_RESPONSE byte = 0x76
+	OATH_TAG_NO_RESPONSE        byte = 0x77
+	OATH_TAG_PROPERTY           byte = 0x78
+	OATH_TAG_VERSION            byte = 0x79
+	OATH_TAG_IMF                byte = 0x7a
+	OATH_TAG_ALGORITHM          byte = 0x7b
+	OATH_TAG_TOUCH              byte = 0x7c
+)
+
+const OATH_PROPERTY_REQUIRE_TOUCH byte = 0x02
+
+const SLOT_DEVICE_SERIAL byte = 0x10
+const OTP_INS_YK2_REQ byte = 0x01
+
+// MGR_INS_READ_CONFIG reads the device info from the management applet
+const MGR_INS_READ_CONFIG byte = 0x1D
@@ -0,0 +1,382 @@
+package oath
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/MeneDev/yubi-oath-vpn/apdu"
+	"github.com/MeneDev/yubi-oath-vpn/yubierror"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Session speaks the Yubico OATH protocol over a transport
+type Session struct {
+	transport apdu.Transport
+}
+
+func SessionNew(transport apdu.Transport) *Session {
+	return &Session{transport: transport}
+}
+
+// SelectResponse is the state reported by the OATH applet when it is selected
+type SelectResponse struct {
+	Version []byte
+	// Salt is the device id used to derive the access key from the password
+	Salt []byte
+	// Challenge is only sent when the applet is protected by a password
+	Challenge []byte
+	Algorithm []byte
+}
+
+func (r SelectResponse) RequiresPassword() bool {
+	return r.Challenge != nil
+}
+
+// CalculateResult is the result of a single credential in a CALCULATE_ALL response
+type CalculateResult struct {
+	Name string
+	// Truncated is the digits byte followed by the truncated code, it is empty if RequiresTouch or Hotp is set
+	Truncated     []byte
+	RequiresTouch bool
+	Hotp          bool
+}
+
+// DeriveKey derives the access key from the password, the salt is the device id sent in the SELECT response
+func DeriveKey(pwd string, salt []byte) []byte {
+	return pbkdf2.Key([]byte(pwd), salt, 1000, 16, sha1.New)
+}
+
+// Send transmits a short APDU and collects the chained response, data is limited to 255 bytes
+func (s *Session) Send(cl byte, ins byte, p1 byte, p2 byte, data []byte) ([]byte, error) {
+	if len(data) > 255 {
+		log.Error().Int("length", len(data)).Msg("command data does not fit into a short APDU")
+		return nil, yubierror.ErrorCommandTooLong
+	}
+
+	transport := s.transport
+	header := []byte{cl, ins, p1, p2, byte(len(data))}
+	telegram := append(header, data...)
+
+	log.Debug().Hex("value", telegram).Msg("sending apdu")
+
+	rsp, err := transport.Transmit(telegram)
+
+	if err != nil {
+		return rsp, err
+	}
+
+	var result []byte
+	for {
+		log.Debug().Hex("value", rsp).Msg("received response")
+
+		if len(rsp) < 2 {
+			return rsp, fmt.Errorf("%w: response without status word", yubierror.ErrorMalformedResponse)
+		}
+
+		result = append(result, rsp[:len(rsp)-2]...)
+
+		// SW1 0x61 signals that more data is available, SW2 is the (possibly truncated) number of remaining bytes
+		if rsp[len(rsp)-2] != 0x61 {
+			break
+		}
+
+		log.Debug().Uint8("remaining", rsp[len(rsp)-1]).Msg("fetching remaining response")
+		rsp, err = transport.Transmit([]byte{cl, byte(SEND_REMAINING), 0, 0})
+		if err != nil {
+			return rsp, err
+		}
+	}
+
+	chk := binary.BigEndian.Uint16(rsp[len(rsp)-2:])
+	if chk != 0x9000 {
+		return rsp, yubierror.StatusErrorNew(chk)
+	}
+
+	return result, nil
+}
+
+func (s *Session) SelectAid(aid AID) ([]byte, error) {
+	return s.Send(0, GP_INS_SELECT, 0x04, 0, aid)
+}
+
+// ReadSerial reads the serial number, the OTP applet must be selected
+func (s *Session) ReadSerial() (uint32, error) {
+	resp, err := s.Send(0, OTP_INS_YK2_REQ, SLOT_DEVICE_SERIAL, 0, []byte{})
+	if err != nil {
+		return 0, err
+	}
+	if len(resp) < 4 {
+		return 0, fmt.Errorf("%w: serial truncated", yubierror.ErrorMalformedResponse)
+	}
+	return binary.BigEndian.Uint32(resp), nil
+}
+
+// Select selects the OATH applet
+func (s *Session) Select() (SelectResponse, error) {
+	resp, err := s.SelectAid(AID_OATH)
+	if err != nil {
+		log.Error().Err(err).Msg("Error setting 'AID_OATH'")
+		return SelectResponse{}, err
+	}
+
+	tlvs, err := ParseTlvs(resp)
+	if err != nil {
+		return SelectResponse{}, err
+	}
+
+	var selected SelectResponse
+	for _, tlv := range tlvs {
+		switch tlv.Tag {
+		case OATH_TAG_VERSION:
+			selected.Version = tlv.Value
+		case OATH_TAG_NAME:
+			selected.Salt = tlv.Value
+		case OATH_TAG_CHALLENGE:
+			selected.Challenge = tlv.Value
+		case OATH_TAG_ALGORITHM:
+			selected.Algorithm = tlv.Value
+		}
+	}
+
+	log.Debug().
+		Hex("raw_name", selected.Salt).
+		Hex("algorithm", selected.Algorithm).
+		Hex("version", selected.Version).
+		Msg("response")
+
+	return selected, nil
+}
+
+// Validate authenticates with the password and verifies that the key knows the password as well.
+// Nothing is sent if the applet is not protected by a password.
+func (s *Session) Validate(pwd string, selected SelectResponse) error {
+	if !selected.RequiresPassword() {
+		log.Debug().Msg("no password set, skipping validation")
+		return nil
+	}
+
+	return s.ValidateKey(DeriveKey(pwd, selected.Salt), selected)
+}
+
+// ValidateKey authenticates with an access key derived by DeriveKey, it skips the expensive key derivation
+func (s *Session) ValidateKey(accessKey []byte, selected SelectResponse) error {
+	h := hmac.New(sha1.New, accessKey)
+	h.Write(selected.Challenge)
+	response := h.Sum(nil)
+	challenge := make([]byte, 8)
+	rand.Read(challenge)
+
+	h = hmac.New(sha1.New, accessKey)
+	h.Write(challenge)
+	verification := h.Sum(nil)
+
+	responseTlv := Tlv{Tag: OATH_TAG_RESPONSE, Value: response}
+	challengeTlv := Tlv{Tag: OATH_TAG_CHALLENGE, Value: challenge}
+
+	verifyResp, err := s.Send(0, byte(VALIDATE), 0, 0, append(responseTlv.Bytes(), challengeTlv.Bytes()...))
+	if errors.Is(err, yubierror.ErrorWrongData) {
+		return yubierror.ErrorWrongPassword
+	}
+	if err != nil {
+		return err
+	}
+
+	verifyTlvs, err := ParseTlvs(verifyResp)
+	if err != nil {
+		return err
+	}
+	received, _ := FindTlv(verifyTlvs, OATH_TAG_RESPONSE)
+
+	log.Debug().
+		Hex("expected", verification).
+		Hex("received", received.Value).
+		Msg("verification")
+
+	if !hmac.Equal(verification, received.Value) {
+		log.Error().
+			Hex("expected", verification).
+			Hex("received", received.Value).
+			Msg("security event: YubiKey failed mutual authentication")
+		return yubierror.ErrorVerificationFailed
+	}
+
+	return nil
+}
+
+// Unlock selects the OATH applet and validates the password if one is set
+func (s *Session) Unlock(pwd string) (SelectResponse, error) {
+	selected, err := s.Select()
+	if err != nil {
+		return selected, err
+	}
+
+	return selected, s.Validate(pwd, selected)
+}
+
+// SetCode sets the password, an empty password removes it. The session must be validated.
+func (s *Session) SetCode(newPwd string, selected SelectResponse) error {
+	var data []byte
+	if newPwd == "" {
+		// an empty key removes the password
+		data = Tlv{Tag: OATH_TAG_KEY, Value: []byte{}}.Bytes()
+	} else {
+		newKey := DeriveKey(newPwd, selected.Salt)
+
+		challenge := make([]byte, 8)
+		rand.Read(challenge)
+
+		h := hmac.New(sha1.New, newKey)
+		h.Write(challenge)
+		response := h.Sum(nil)
+
+		keyTlv := Tlv{Tag: OATH_TAG_KEY, Value: append([]byte{byte(TOTP) | byte(SHA1)}, newKey...)}
+		challengeTlv := Tlv{Tag: OATH_TAG_CHALLENGE, Value: challenge}
+		responseTlv := Tlv{Tag: OATH_TAG_RESPONSE, Value: response}
+
+		data = append(append(keyTlv.Bytes(), challengeTlv.Bytes()...), responseTlv.Bytes()...)
+	}
+
+	_, err := s.Send(0, byte(SET_CODE), 0, 0, data)
+	return err
+}
+
+// List lists the credentials, LIST does not report if a credential requires touch
+func (s *Session) List() ([]Credential, error) {
+	listResp, err := s.Send(0, byte(LIST), 0, 0, []byte{})
+	if err != nil {
+		log.Error().Err(err).Msg("error listing credentials")
+		return nil, err
+	}
+
+	listTlvs, err := ParseTlvs(listResp)
+	if err != nil {
+		return nil, err
+	}
+
+	var creds []Credential
+	for _, tlv := range listTlvs {
+		if tlv.Tag != OATH_TAG_NAME_LIST || len(tlv.Value) < 1 {
+			continue
+		}
+
+		name := string(tlv.Value[1:])
+		oathType, algorithm := ParseTypeAndAlgorithm(tlv.Value[0])
+		period, issuer, account := ParseCredentialName(name)
+		if oathType != TOTP {
+			period = 0
+		}
+
+		creds = append(creds, Credential{
+			Name:      name,
+			Issuer:    issuer,
+			Account:   account,
+			Type:      oathType,
+			Algorithm: algorithm,
+			Period:    period,
+		})
+	}
+
+	return creds, nil
+}
+
+// CalculateAll calculates the truncated codes of all TOTP credentials that do not require touch
+func (s *Session) CalculateAll(challenge []byte) ([]CalculateResult, error) {
+	challengeTlv := Tlv{Tag: OATH_TAG_CHALLENGE, Value: challenge}
+
+	rsp, err := s.Send(0, byte(CALCULATE_ALL), 0, 0x01, challengeTlv.Bytes())
+	if err != nil {
+		log.Error().Err(err).Msg("error calculating codes")
+		return nil, err
+	}
+	log.Debug().Hex("value", rsp).Msg("calculate all")
+
+	tlvs, err := ParseTlvs(rsp)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []CalculateResult
+	for _, tlv := range tlvs {
+		if tlv.Tag == OATH_TAG_NAME {
+			results = append(results, CalculateResult{Name: string(tlv.Value)})
+			continue
+		}
+
+		if len(results) == 0 {
+			continue
+		}
+
+		current := &results[len(results)-1]
+		switch tlv.Tag {
+		case OATH_TAG_TRUNCATED_RESPONSE:
+			current.Truncated = tlv.Value
+		case OATH_TAG_TOUCH:
+			current.RequiresTouch = true
+		case OATH_TAG_NO_RESPONSE:
+			current.Hotp = true
+		}
+	}
+
+	return results, nil
+}
+
+// Calculate calculates a single truncated code, the challenge is empty for HOTP credentials and the time step for TOTP credentials
+func (s *Session) Calculate(name string, challenge []byte) ([]byte, error) {
+	nameTlv := Tlv{Tag: OATH_TAG_NAME, Value: []byte(name)}
+	challengeTlv := Tlv{Tag: OATH_TAG_CHALLENGE, Value: challenge}
+
+	rsp, err := s.Send(0, byte(CALCULATE), 0, 0x01, append(nameTlv.Bytes(), challengeTlv.Bytes()...))
+	if err != nil {
+		log.Error().Err(err).Str("slot", name).Msg("error calculating code")
+		return nil, err
+	}
+
+	tlvs, err := ParseTlvs(rsp)
+	if err != nil {
+		return nil, err
+	}
+
+	if tlv, ok := FindTlv(tlvs, OATH_TAG_TRUNCATED_RESPONSE); ok {
+		return tlv.Value, nil
+	}
+
+	return nil, fmt.Errorf("%w: no truncated response for %s", yubierror.ErrorMalformedResponse, name)
+}
+
+func (s *Session) Put(credential CredentialData) error {
+	nameTlv := Tlv{Tag: OATH_TAG_NAME, Value: []byte(credential.Name())}
+	keyTlv := Tlv{Tag: OATH_TAG_KEY, Value: credential.Key()}
+
+	data := append(nameTlv.Bytes(), keyTlv.Bytes()...)
+	if credential.RequiresTouch {
+		// the property is sent without a length byte
+		data = append(data, OATH_TAG_PROPERTY, OATH_PROPERTY_REQUIRE_TOUCH)
+	}
+	if credential.Type == HOTP && credential.Counter > 0 {
+		counter := make([]byte, 4)
+		binary.BigEndian.PutUint32(counter, credential.Counter)
+		data = append(data, Tlv{Tag: OATH_TAG_IMF, Value: counter}.Bytes()...)
+	}
+
+	_, err := s.Send(0, byte(PUT), 0, 0, data)
+	return err
+}
+
+func (s *Session) Delete(name string) error {
+	_, err := s.Send(0, byte(DELETE), 0, 0, Tlv{Tag: OATH_TAG_NAME, Value: []byte(name)}.Bytes())
+	return err
+}
+
+// Rename renames a credential, requires firmware 5.3 or newer
+func (s *Session) Rename(name string, newName string) error {
+	nameTlv := Tlv{Tag: OATH_TAG_NAME, Value: []byte(name)}
+	newNameTlv := Tlv{Tag: OATH_TAG_NAME, Value: []byte(newName)}
+
+	_, err := s.Send(0, byte(RENAME), 0, 0, append(nameTlv.Bytes(), newNameTlv.Bytes()...))
+	return err
+}
@@ -0,0 +1,71 @@
+package oath
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/MeneDev/yubi-oath-vpn/yubierror"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingTransport struct {
+	commands [][]byte
+	// response is returned for every command, nil is a plain success
+	response []byte
+}
+
+func (r *recordingTransport) Transmit(command []byte) ([]byte, error) {
+	r.commands = append(r.commands, command)
+	if r.response != nil {
+		return r.response, nil
+	}
+	return []byte{0x90, 0x00}, nil
+}
+
+func (r *recordingTransport) BeginTransaction() error { return nil }
+func (r *recordingTransport) EndTransaction() error   { return nil }
+func (r *recordingTransport) Reconnect() error        { return nil }
+
+func TestSession_Send(t *testing.T) {
+	t.Run("data of 255 bytes fits into Lc", func(t *testing.T) {
+		transport := &recordingTransport{}
+		session := SessionNew(transport)
+
+		_, err := session.Send(0, byte(PUT), 0, 0, make([]byte, 255))
+
+		assert.NoError(t, err)
+		assert.Len(t, transport.commands, 1)
+		assert.Equal(t, byte(255), transport.commands[0][4])
+		assert.Len(t, transport.commands[0], 5+255)
+	})
+
+	t.Run("longer data is not truncated", func(t *testing.T) {
+		transport := &recordingTransport{}
+		session := SessionNew(transport)
+
+		_, err := session.Send(0, byte(PUT), 0, 0, make([]byte, 256))
+
+		assert.Equal(t, yubierror.ErrorCommandTooLong, err)
+		assert.Empty(t, transport.commands)
+	})
+}
+
+func TestSession_MalformedResponses(t *testing.T) {
+	t.Run("response without status word", func(t *testing.T) {
+		_, err := SessionNew(&recordingTransport{response: []byte{0x90}}).Send(0, byte(LIST), 0, 0, nil)
+
+		assert.True(t, errors.Is(err, yubierror.ErrorMalformedResponse), "unexpected error %v", err)
+	})
+
+	t.Run("serial truncated", func(t *testing.T) {
+		_, err := SessionNew(&recordingTransport{response: []byte{0x00, 0x9a, 0x90, 0x00}}).ReadSerial()
+
+		assert.True(t, errors.Is(err, yubierror.ErrorMalformedResponse), "unexpected error %v", err)
+	})
+
+	t.Run("calculate without truncated response", func(t *testing.T) {
+		_, err := SessionNew(&recordingTransport{}).Calculate("totp", []byte{0, 0, 0, 0, 0, 0, 0, 1})
+
+		assert.True(t, errors.Is(err, yubierror.ErrorMalformedResponse), "unexpected error %v", err)
+	})
+}
@@ -0,0 +1,95 @@
+package oath
+
+import (
+	"fmt"
+
+	"github.com/MeneDev/yubi-oath-vpn/yubierror"
+)
+
+// maxLengthBytes limits the BER long form to lengths that fit into an int on every platform
+const maxLengthBytes = 3
+
+// Tlv is a BER-TLV with a single byte tag, which is all the OATH applet uses
+type Tlv struct {
+	Tag   byte
+	Value []byte
+}
+
+// ParseTlv parses the first TLV of data and returns the remaining bytes.
+// Lengths may use the BER short or long form, malformed or truncated input is reported as an error.
+func ParseTlv(data []byte) (Tlv, []byte, error) {
+	if len(data) < 2 {
+		return Tlv{}, nil, fmt.Errorf("%w: TLV header truncated", yubierror.ErrorMalformedResponse)
+	}
+
+	tag := data[0]
+	ln := int(data[1])
+	offs := 2
+
+	if ln&0x80 != 0 {
+		nBytes := ln & 0x7f
+		if nBytes == 0 || nBytes > maxLengthBytes {
+			return Tlv{}, nil, fmt.Errorf("%w: unsupported length encoding 0x%02x for tag 0x%02x", yubierror.ErrorMalformedResponse, data[1], tag)
+		}
+		if len(data) < offs+nBytes {
+			return Tlv{}, nil, fmt.Errorf("%w: length of tag 0x%02x truncated", yubierror.ErrorMalformedResponse, tag)
+		}
+
+		ln = 0
+		for _, b := range data[offs : offs+nBytes] {
+			ln = ln<<8 | int(b)
+		}
+		offs += nBytes
+	}
+
+	if len(data)-offs < ln {
+		return Tlv{}, nil, fmt.Errorf("%w: value of tag 0x%02x truncated, expected %d bytes but got %d", yubierror.ErrorMalformedResponse, tag, ln, len(data)-offs)
+	}
+
+	return Tlv{Tag: tag, Value: data[offs : offs+ln]}, data[offs+ln:], nil
+}
+
+// ParseTlvs parses all TLVs of a response in order, duplicate tags are kept
+func ParseTlvs(response []byte) ([]Tlv, error) {
+	var tlvs []Tlv
+	for len(response) > 0 {
+		tlv, rest, err := ParseTlv(response)
+		if err != nil {
+			return nil, err
+		}
+
+		tlvs = append(tlvs, tlv)
+		response = rest
+	}
+
+	return tlvs, nil
+}
+
+// FindTlv returns the first TLV with the tag
+func FindTlv(tlvs []Tlv, tag byte) (Tlv, bool) {
+	for _, tlv := range tlvs {
+		if tlv.Tag == tag {
+			return tlv, true
+		}
+	}
+	return Tlv{}, false
+}
+
+// Bytes encodes the TLV, lengths of 128 bytes or more use the BER long form
+func (self Tlv) Bytes() []byte {
+	res := []byte{self.Tag}
+
+	ln := len(self.Value)
+	switch {
+	case ln < 0x80:
+		res = append(res, byte(ln))
+	case ln <= 0xff:
+		res = append(res, 0x81, byte(ln))
+	case ln <= 0xffff:
+		res = append(res, 0x82, byte(ln>>8), byte(ln))
+	default:
+		res = append(res, 0x83, byte(ln>>16), byte(ln>>8), byte(ln))
+	}
+
+	return append(res, self.Value...)
+}
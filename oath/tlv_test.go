@@ -0,0 +1,101 @@
+package oath
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/MeneDev/yubi-oath-vpn/yubierror"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTlvs(t *testing.T) {
+	t.Run("duplicate tags are kept in order", func(t *testing.T) {
+		tlvs, err := ParseTlvs([]byte{0x71, 0x01, 'a', 0x71, 0x02, 'b', 'c', 0x77, 0x00})
+
+		assert.NoError(t, err)
+		assert.Equal(t, []Tlv{
+			{Tag: 0x71, Value: []byte("a")},
+			{Tag: 0x71, Value: []byte("bc")},
+			{Tag: 0x77, Value: []byte{}},
+		}, tlvs)
+	})
+
+	t.Run("long form length", func(t *testing.T) {
+		value := bytes.Repeat([]byte{0x42}, 300)
+
+		tlvs, err := ParseTlvs(append([]byte{0x75, 0x82, 0x01, 0x2c}, value...))
+
+		assert.NoError(t, err)
+		assert.Equal(t, []Tlv{{Tag: 0x75, Value: value}}, tlvs)
+	})
+
+	for name, data := range map[string][]byte{
+		"missing length":          {0x71},
+		"truncated value":         {0x71, 0x05, 'a'},
+		"truncated long length":   {0x71, 0x82, 0x01},
+		"indefinite length":       {0x71, 0x80, 0x00, 0x00},
+		"length too large":        {0x71, 0x84, 0xff, 0xff, 0xff, 0xff},
+		"truncated after a valid": {0x71, 0x01, 'a', 0x72},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseTlvs(data)
+
+			assert.True(t, errors.Is(err, yubierror.ErrorMalformedResponse), "unexpected error %v", err)
+		})
+	}
+}
+
+func TestTlv_Bytes(t *testing.T) {
+	assert.Equal(t, []byte{0x71, 0x01, 'a'}, Tlv{Tag: 0x71, Value: []byte("a")}.Bytes())
+	assert.Equal(t, []byte{0x71, 0x81, 0x80}, Tlv{Tag: 0x71, Value: make([]byte, 0x80)}.Bytes()[:3])
+	assert.Equal(t, []byte{0x71, 0x82, 0x01, 0x00}, Tlv{Tag: 0x71, Value: make([]byte, 0x100)}.Bytes()[:4])
+}
+
+func FuzzParseTlvs(f *testing.F) {
+	f.Add([]byte{0x79, 0x03, 0x05, 0x02, 0x04, 0x71, 0x08, 0x5b, 0x1c, 0xcc, 0x20, 0xd4, 0xab, 0x2f, 0xdf})
+	f.Add([]byte{0x71, 0x05, 'a', ':', 'b', 'o', 'b', 0x7c, 0x01, 0x06})
+	f.Add([]byte{0x75, 0x81, 0x01, 0x00})
+	f.Add([]byte{0x71, 0x82, 0xff})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tlvs, err := ParseTlvs(data)
+		if err != nil {
+			return
+		}
+
+		// re-encoding uses the shortest length form, so the result must parse to the same TLVs
+		var encoded []byte
+		for _, tlv := range tlvs {
+			encoded = append(encoded, tlv.Bytes()...)
+		}
+
+		reparsed, err := ParseTlvs(encoded)
+		if err != nil {
+			t.Fatalf("re-encoded TLVs do not parse: %v", err)
+		}
+		if len(reparsed) != len(tlvs) {
+			t.Fatalf("expected %d TLVs but got %d", len(tlvs), len(reparsed))
+		}
+		for i := range tlvs {
+			if reparsed[i].Tag != tlvs[i].Tag || !bytes.Equal(reparsed[i].Value, tlvs[i].Value) {
+				t.Fatalf("TLV %d changed from %v to %v", i, tlvs[i], reparsed[i])
+			}
+		}
+	})
+}
+
+func FuzzTlvRoundTrip(f *testing.F) {
+	f.Add(byte(0x71), []byte("name"))
+	f.Add(byte(0x75), bytes.Repeat([]byte{0x42}, 200))
+
+	f.Fuzz(func(t *testing.T, tag byte, value []byte) {
+		tlv, rest, err := ParseTlv(Tlv{Tag: tag, Value: value}.Bytes())
+		if err != nil {
+			t.Fatalf("encoded TLV does not parse: %v", err)
+		}
+		if tlv.Tag != tag || !bytes.Equal(tlv.Value, value) || len(rest) != 0 {
+			t.Fatalf("round trip changed the TLV")
+		}
+	})
+}
@@ -0,0 +1,543 @@
+package virtual
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"hash"
+	"io"
+	"sync"
+
+	"github.com/MeneDev/yubi-oath-vpn/apdu"
+	"github.com/MeneDev/yubi-oath-vpn/oath"
+)
+
+// maxCredentials is the capacity of the OATH applet of a YubiKey 5
+const maxCredentials = 32
+
+var (
+	swOk                    = []byte{0x90, 0x00}
+	swWrongLength           = []byte{0x67, 0x00}
+	swAuthRequired          = []byte{0x69, 0x82}
+	swNoSuchObject          = []byte{0x69, 0x84}
+	swWrongData             = []byte{0x6A, 0x80}
+	swFileNotFound          = []byte{0x6A, 0x82}
+	swNoSpace               = []byte{0x6A, 0x84}
+	swWrongParameters       = []byte{0x6A, 0x86}
+	swInstructionNotSupport = []byte{0x6D, 0x00}
+)
+
+// Credential is a credential stored in the virtual applet
+type Credential struct {
+	// Name including the optional period prefix, e.g. 60/Example:user@example.com
+	Name          string
+	Type          oath.Type
+	Algorithm     oath.Algorithm
+	Digits        int
+	Secret        []byte
+	Counter       uint32
+	RequiresTouch bool
+}
+
+// Config describes the initial state of the virtual applet
+type Config struct {
+	// Version is the firmware version, e.g. {5, 4, 3}
+	Version [3]byte
+	Serial  uint32
+	// Password protects the OATH applet, an empty password leaves it unprotected
+	Password    string
+	Credentials []Credential
+	// DeviceId is the salt for the password derivation, a random one is generated if empty
+	DeviceId []byte
+	// Touch is called when a touch-required credential is calculated and reports if the user touched the key.
+	// Without it, touch-required credentials time out.
+	Touch func(name string) bool
+	// MaxResponseLength splits longer responses into chained responses (SW 61xx), 0 disables chaining
+	MaxResponseLength int
+	// Random is the source for challenges, defaults to crypto/rand
+	Random io.Reader
+}
+
+var _ apdu.Transport = (*Applet)(nil)
+
+// Applet is a software implementation of the Yubico OATH applet speaking APDUs
+type Applet struct {
+	mu            sync.Mutex
+	config        Config
+	credentials   []Credential
+	accessKey     []byte
+	selected      []byte
+	authenticated bool
+	challenge     []byte
+	pending       []byte
+}
+
+func AppletNew(config Config) *Applet {
+	if config.Random == nil {
+		config.Random = rand.Reader
+	}
+	if len(config.DeviceId) == 0 {
+		config.DeviceId = make([]byte, 8)
+		io.ReadFull(config.Random, config.DeviceId)
+	}
+
+	applet := &Applet{config: config}
+	applet.credentials = append(applet.credentials, config.Credentials...)
+	if config.Password != "" {
+		applet.accessKey = oath.DeriveKey(config.Password, config.DeviceId)
+	}
+
+	return applet
+}
+
+// Credentials returns a copy of the credentials currently stored
+func (a *Applet) Credentials() []Credential {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return append([]Credential{}, a.credentials...)
+}
+
+func (a *Applet) BeginTransaction() error {
+	return nil
+}
+
+func (a *Applet) EndTransaction() error {
+	return nil
+}
+
+func (a *Applet) Reconnect() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.selected = nil
+	a.authenticated = false
+	a.pending = nil
+	return nil
+}
+
+func (a *Applet) Transmit(command []byte) ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(command) < 4 {
+		return swWrongLength, nil
+	}
+
+	ins, p1, p2 := command[1], command[2], command[3]
+
+	var data []byte
+	if len(command) > 4 {
+		lc := int(command[4])
+		if len(command) < 5+lc {
+			return swWrongLength, nil
+		}
+		data = command[5 : 5+lc]
+	}
+
+	if ins == byte(oath.SEND_REMAINING) && bytes.Equal(a.selected, oath.AID_OATH) {
+		return a.respond(a.pending, swOk), nil
+	}
+	a.pending = nil
+
+	if ins == oath.GP_INS_SELECT && p1 == 0x04 {
+		return a.selectAid(data), nil
+	}
+
+	switch {
+	case bytes.Equal(a.selected, oath.AID_OTP):
+		return a.otp(ins, p1), nil
+	case bytes.Equal(a.selected, oath.AID_MGR):
+		return a.mgr(ins), nil
+	case bytes.Equal(a.selected, oath.AID_OATH):
+		return a.oathApplet(ins, p1, p2, data), nil
+	}
+
+	return swInstructionNotSupport, nil
+}
+
+// respond returns as much of the response as allowed and keeps the rest for SEND_REMAINING
+func (a *Applet) respond(response []byte, sw []byte) []byte {
+	max := a.config.MaxResponseLength
+	if max <= 0 || len(response) <= max {
+		a.pending = nil
+		return append(append([]byte{}, response...), sw...)
+	}
+
+	a.pending = response[max:]
+	remaining := len(a.pending)
+	if remaining > 0xff {
+		remaining = 0
+	}
+	return append(append([]byte{}, response[:max]...), 0x61, byte(remaining))
+}
+
+func (a *Applet) selectAid(aid []byte) []byte {
+	a.authenticated = false
+
+	switch {
+	case bytes.Equal(aid, oath.AID_OTP):
+		a.selected = oath.AID_OTP
+		return append([]byte{a.config.Version[0], a.config.Version[1], a.config.Version[2], 0x00, 0x00, 0x00}, swOk...)
+	case bytes.Equal(aid, oath.AID_MGR):
+		a.selected = oath.AID_MGR
+		version := []byte{'0' + a.config.Version[0], '.', '0' + a.config.Version[1], '.', '0' + a.config.Version[2]}
+		return append(version, swOk...)
+	case bytes.Equal(aid, oath.AID_OATH):
+		a.selected = oath.AID_OATH
+
+		response := tlv(oath.OATH_TAG_VERSION, a.config.Version[:])
+		response = append(response, tlv(oath.OATH_TAG_NAME, a.config.DeviceId)...)
+		if a.accessKey != nil {
+			a.challenge = make([]byte, 8)
+			io.ReadFull(a.config.Random, a.challenge)
+			response = append(response, tlv(oath.OATH_TAG_CHALLENGE, a.challenge)...)
+			response = append(response, tlv(oath.OATH_TAG_ALGORITHM, []byte{byte(oath.SHA1)})...)
+		}
+		return a.respond(response, swOk)
+	}
+
+	a.selected = nil
+	return swFileNotFound
+}
+
+func (a *Applet) otp(ins byte, p1 byte) []byte {
+	if ins == oath.OTP_INS_YK2_REQ && p1 == oath.SLOT_DEVICE_SERIAL {
+		serial := make([]byte, 4)
+		binary.BigEndian.PutUint32(serial, a.config.Serial)
+		return append(serial, swOk...)
+	}
+	return swInstructionNotSupport
+}
+
+func (a *Applet) mgr(ins byte) []byte {
+	if ins != oath.MGR_INS_READ_CONFIG {
+		return swInstructionNotSupport
+	}
+
+	serial := make([]byte, 4)
+	binary.BigEndian.PutUint32(serial, a.config.Serial)
+
+	var info []byte
+	info = append(info, tlv(0x02, serial)...)
+	info = append(info, tlv(0x05, a.config.Version[:])...)
+	// USB-A keychain
+	info = append(info, tlv(0x04, []byte{0x01})...)
+
+	return append(append([]byte{byte(len(info))}, info...), swOk...)
+}
+
+func (a *Applet) oathApplet(ins byte, p1 byte, p2 byte, data []byte) []byte {
+	tlvs, ok := parseTlvs(data)
+	if !ok {
+		return swWrongData
+	}
+
+	if ins == byte(oath.VALIDATE) {
+		return a.validate(tlvs)
+	}
+
+	if ins == byte(oath.RESET) {
+		if p1 != 0xde || p2 != 0xad {
+			return swWrongParameters
+		}
+		a.credentials = nil
+		a.accessKey = nil
+		a.authenticated = false
+		a.config.DeviceId = make([]byte, 8)
+		io.ReadFull(a.config.Random, a.config.DeviceId)
+		return swOk
+	}
+
+	if a.accessKey != nil && !a.authenticated {
+		return swAuthRequired
+	}
+
+	switch ins {
+	case byte(oath.LIST):
+		return a.list()
+	case byte(oath.CALCULATE):
+		return a.calculate(tlvs, p2 == 0x01)
+	case byte(oath.CALCULATE_ALL):
+		return a.calculateAll(tlvs, p2 == 0x01)
+	case byte(oath.PUT):
+		return a.put(tlvs)
+	case byte(oath.DELETE):
+		return a.delete(tlvs)
+	case byte(oath.RENAME):
+		return a.rename(tlvs)
+	case byte(oath.SET_CODE):
+		return a.setCode(tlvs)
+	}
+
+	return swInstructionNotSupport
+}
+
+func (a *Applet) validate(tlvs []oath.Tlv) []byte {
+	if a.accessKey == nil || a.challenge == nil {
+		return swAuthRequired
+	}
+
+	response, okResponse := find(tlvs, oath.OATH_TAG_RESPONSE)
+	hostChallenge, okChallenge := find(tlvs, oath.OATH_TAG_CHALLENGE)
+	if !okResponse || !okChallenge {
+		return swWrongData
+	}
+
+	// a challenge can only be answered once
+	expected := hmacSha1(a.accessKey, a.challenge)
+	a.challenge = nil
+	if !hmac.Equal(expected, response) {
+		return swWrongData
+	}
+
+	a.authenticated = true
+	return a.respond(tlv(oath.OATH_TAG_RESPONSE, hmacSha1(a.accessKey, hostChallenge)), swOk)
+}
+
+func (a *Applet) list() []byte {
+	var response []byte
+	for _, cred := range a.credentials {
+		value := append([]byte{byte(cred.Type) | byte(cred.Algorithm)}, cred.Name...)
+		response = append(response, tlv(oath.OATH_TAG_NAME_LIST, value)...)
+	}
+	return a.respond(response, swOk)
+}
+
+func (a *Applet) calculate(tlvs []oath.Tlv, truncate bool) []byte {
+	name, ok := find(tlvs, oath.OATH_TAG_NAME)
+	if !ok {
+		return swWrongData
+	}
+	challenge, ok := find(tlvs, oath.OATH_TAG_CHALLENGE)
+	if !ok {
+		return swWrongData
+	}
+
+	idx := a.indexOf(string(name))
+	if idx < 0 {
+		return swNoSuchObject
+	}
+	cred := &a.credentials[idx]
+
+	if cred.RequiresTouch && (a.config.Touch == nil || !a.config.Touch(cred.Name)) {
+		return swAuthRequired
+	}
+
+	if cred.Type == oath.HOTP {
+		challenge = make([]byte, 8)
+		binary.BigEndian.PutUint64(challenge, uint64(cred.Counter))
+		cred.Counter++
+	}
+
+	return a.respond(codeTlv(*cred, challenge, truncate), swOk)
+}
+
+func (a *Applet) calculateAll(tlvs []oath.Tlv, truncate bool) []byte {
+	challenge, ok := find(tlvs, oath.OATH_TAG_CHALLENGE)
+	if !ok {
+		return swWrongData
+	}
+
+	var response []byte
+	for _, cred := range a.credentials {
+		response = append(response, tlv(oath.OATH_TAG_NAME, []byte(cred.Name))...)
+
+		switch {
+		case cred.RequiresTouch:
+			response = append(response, tlv(oath.OATH_TAG_TOUCH, []byte{byte(cred.Digits)})...)
+		case cred.Type == oath.HOTP:
+			response = append(response, tlv(oath.OATH_TAG_NO_RESPONSE, []byte{byte(cred.Digits)})...)
+		default:
+			response = append(response, codeTlv(cred, challenge, truncate)...)
+		}
+	}
+
+	return a.respond(response, swOk)
+}
+
+func (a *Applet) put(tlvs []oath.Tlv) []byte {
+	name, okName := find(tlvs, oath.OATH_TAG_NAME)
+	key, okKey := find(tlvs, oath.OATH_TAG_KEY)
+	if !okName || !okKey || len(key) < 2 || len(name) > oath.MaxNameLength {
+		return swWrongData
+	}
+
+	oathType, algorithm := oath.ParseTypeAndAlgorithm(key[0])
+	cred := Credential{
+		Name:      string(name),
+		Type:      oathType,
+		Algorithm: algorithm,
+		Digits:    int(key[1]),
+		Secret:    append([]byte{}, key[2:]...),
+	}
+
+	if property, ok := find(tlvs, oath.OATH_TAG_PROPERTY); ok && len(property) == 1 {
+		cred.RequiresTouch = property[0]&oath.OATH_PROPERTY_REQUIRE_TOUCH != 0
+	}
+
+	if imf, ok := find(tlvs, oath.OATH_TAG_IMF); ok && len(imf) == 4 {
+		cred.Counter = binary.BigEndian.Uint32(imf)
+	}
+
+	if idx := a.indexOf(cred.Name); idx >= 0 {
+		a.credentials[idx] = cred
+		return swOk
+	}
+
+	if len(a.credentials) >= maxCredentials {
+		return swNoSpace
+	}
+
+	a.credentials = append(a.credentials, cred)
+	return swOk
+}
+
+func (a *Applet) delete(tlvs []oath.Tlv) []byte {
+	name, ok := find(tlvs, oath.OATH_TAG_NAME)
+	if !ok {
+		return swWrongData
+	}
+
+	idx := a.indexOf(string(name))
+	if idx < 0 {
+		return swNoSuchObject
+	}
+
+	a.credentials = append(a.credentials[:idx], a.credentials[idx+1:]...)
+	return swOk
+}
+
+func (a *Applet) rename(tlvs []oath.Tlv) []byte {
+	// RENAME was introduced with firmware 5.3.1
+	v := a.config.Version
+	if v[0] < 5 || (v[0] == 5 && (v[1] < 3 || (v[1] == 3 && v[2] < 1))) {
+		return swInstructionNotSupport
+	}
+
+	var names [][]byte
+	for _, t := range tlvs {
+		if t.Tag == oath.OATH_TAG_NAME {
+			names = append(names, t.Value)
+		}
+	}
+	if len(names) != 2 || len(names[1]) > oath.MaxNameLength {
+		return swWrongData
+	}
+
+	idx := a.indexOf(string(names[0]))
+	if idx < 0 {
+		return swNoSuchObject
+	}
+	if a.indexOf(string(names[1])) >= 0 {
+		return swWrongData
+	}
+
+	a.credentials[idx].Name = string(names[1])
+	return swOk
+}
+
+func (a *Applet) setCode(tlvs []oath.Tlv) []byte {
+	key, ok := find(tlvs, oath.OATH_TAG_KEY)
+	if !ok {
+		return swWrongData
+	}
+
+	if len(key) == 0 {
+		a.accessKey = nil
+		return swOk
+	}
+
+	challenge, okChallenge := find(tlvs, oath.OATH_TAG_CHALLENGE)
+	response, okResponse := find(tlvs, oath.OATH_TAG_RESPONSE)
+	if len(key) != 17 || !okChallenge || !okResponse {
+		return swWrongData
+	}
+
+	// the host proves it knows the new key
+	if !hmac.Equal(hmacSha1(key[1:], challenge), response) {
+		return swWrongData
+	}
+
+	a.accessKey = append([]byte{}, key[1:]...)
+	return swOk
+}
+
+func (a *Applet) indexOf(name string) int {
+	for i, cred := range a.credentials {
+		if cred.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func codeTlv(cred Credential, challenge []byte, truncate bool) []byte {
+	h := hmac.New(hashFunc(cred.Algorithm), cred.Secret)
+	h.Write(challenge)
+	sum := h.Sum(nil)
+
+	if !truncate {
+		return tlv(oath.OATH_TAG_RESPONSE, append([]byte{byte(cred.Digits)}, sum...))
+	}
+
+	offset := sum[len(sum)-1] & 0x0f
+	truncated := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	value := make([]byte, 5)
+	value[0] = byte(cred.Digits)
+	binary.BigEndian.PutUint32(value[1:], truncated)
+
+	return tlv(oath.OATH_TAG_TRUNCATED_RESPONSE, value)
+}
+
+func hashFunc(algorithm oath.Algorithm) func() hash.Hash {
+	switch algorithm {
+	case oath.SHA256:
+		return sha256.New
+	case oath.SHA512:
+		return sha512.New
+	}
+	return sha1.New
+}
+
+func hmacSha1(key []byte, data []byte) []byte {
+	h := hmac.New(sha1.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func tlv(tag byte, value []byte) []byte {
+	return oath.Tlv{Tag: tag, Value: value}.Bytes()
+}
+
+func find(tlvs []oath.Tlv, tag byte) ([]byte, bool) {
+	tlv, ok := oath.FindTlv(tlvs, tag)
+	return tlv.Value, ok
+}
+
+// parseTlvs parses the TLVs sent by the host, the property tag is sent without a length
+func parseTlvs(data []byte) ([]oath.Tlv, bool) {
+	var tlvs []oath.Tlv
+	for len(data) > 0 {
+		if data[0] == oath.OATH_TAG_PROPERTY {
+			if len(data) < 2 {
+				return nil, false
+			}
+			tlvs = append(tlvs, oath.Tlv{Tag: oath.OATH_TAG_PROPERTY, Value: data[1:2]})
+			data = data[2:]
+			continue
+		}
+
+		tlv, rest, err := oath.ParseTlv(data)
+		if err != nil {
+			return nil, false
+		}
+		tlvs = append(tlvs, tlv)
+		data = rest
+	}
+	return tlvs, true
+}
@@ -0,0 +1,55 @@
+package virtual
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/MeneDev/yubi-oath-vpn/oath"
+	"github.com/MeneDev/yubi-oath-vpn/yubierror"
+	"github.com/stretchr/testify/assert"
+)
+
+// vectors from oath/challenge_test.go
+var salt = []byte{0x5b, 0x1c, 0xcc, 0x20, 0xd4, 0xab, 0x2f, 0xdf}
+var challenge = []byte{0xD3, 0x47, 0x6C, 0xC6, 0x00, 0x52, 0x4A, 0x5C}
+var expectedResponse = []byte{0x55, 0xC0, 0x1A, 0x95, 0xA6, 0x8F, 0xBD, 0x54, 0x4A, 0xAF, 0x4A, 0x4A, 0x51, 0x52, 0x5B, 0x91, 0xF2, 0x6A, 0x39, 0x8B}
+
+func TestApplet_SelectAndValidate(t *testing.T) {
+	applet := AppletNew(Config{
+		Version:  [3]byte{5, 2, 4},
+		Password: "abc",
+		DeviceId: salt,
+		Random:   bytes.NewReader(append(append([]byte{}, challenge...), challenge...)),
+	})
+
+	rsp, _ := applet.Transmit(append([]byte{0x00, 0xa4, 0x04, 0x00, byte(len(oath.AID_OATH))}, oath.AID_OATH...))
+	assert.Equal(t, []byte{0x90, 0x00}, rsp[len(rsp)-2:])
+	assert.Contains(t, string(rsp), string(tlv(oath.OATH_TAG_NAME, salt)))
+	assert.Contains(t, string(rsp), string(tlv(oath.OATH_TAG_CHALLENGE, challenge)))
+
+	rsp, _ = applet.Transmit([]byte{0x00, 0xa1, 0x00, 0x00})
+	assert.Equal(t, []byte{0x69, 0x82}, rsp)
+
+	data := append(tlv(oath.OATH_TAG_RESPONSE, expectedResponse), tlv(oath.OATH_TAG_CHALLENGE, challenge)...)
+	rsp, _ = applet.Transmit(append([]byte{0x00, 0xa3, 0x00, 0x00, byte(len(data))}, data...))
+	assert.Equal(t, append(tlv(oath.OATH_TAG_RESPONSE, expectedResponse), 0x90, 0x00), rsp)
+}
+
+func TestApplet_CalculateWithoutValidation(t *testing.T) {
+	applet := AppletNew(Config{
+		Version:  [3]byte{5, 2, 4},
+		Password: "abc",
+		Credentials: []Credential{
+			{Name: "totp", Type: oath.TOTP, Algorithm: oath.SHA1, Digits: 6, Secret: []byte("12345678901234567890")},
+		},
+	})
+	session := oath.SessionNew(applet)
+
+	_, err := session.Select()
+	assert.NoError(t, err)
+
+	_, err = session.Calculate("totp", challenge)
+	assert.True(t, errors.Is(err, yubierror.ErrorAuthRequired))
+	assert.False(t, errors.Is(err, yubierror.ErrorTouchTimeout))
+}
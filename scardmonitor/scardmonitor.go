@@ -23,6 +23,8 @@ type ScardChangeEvent interface {
 	Id() string
 	ScardContext() *scard.Context
 	Context() context.Context
+	// Time is when the change was detected
+	Time() time.Time
 }
 
 var _ ScardChangeEvent = (*scardChangeEvent)(nil)
@@ -32,12 +34,17 @@ type scardChangeEvent struct {
 	id       string
 	scardCtx *scard.Context
 	ctx      context.Context
+	time     time.Time
 }
 
 func (ev scardChangeEvent) Context() context.Context {
 	return ev.ctx
 }
 
+func (ev scardChangeEvent) Time() time.Time {
+	return ev.time
+}
+
 func (ev scardChangeEvent) ScardContext() *scard.Context {
 	return ev.scardCtx
 }
@@ -248,6 +255,7 @@ func (mon *scardMon) updateLoop() {
 					presence: Available,
 					scardCtx: ctx,
 					ctx:      cancelCtx,
+					time:     time.Now(),
 				}
 			}
 
@@ -255,15 +263,17 @@ func (mon *scardMon) updateLoop() {
 			if state.CurrentState&scard.StatePresent != 0 && state.EventState&scard.StatePresent == 0 {
 				log.Info().Str("reader", state.Reader).Msg("Reader removed")
 				removed = true
-				//mon.readerPresenceChan <- scardChangeEvent{
-				//	id:       state.Reader,
-				//	presence: Unavailable,
-				//	scardCtx: ctx,
-				//	ctx:      mon.ctx,
-				//}
 
 				cancel := state.UserData.(context.CancelFunc)
 				cancel()
+
+				mon.readerPresenceChan <- scardChangeEvent{
+					id:       state.Reader,
+					presence: Unavailable,
+					scardCtx: ctx,
+					ctx:      mon.ctx,
+					time:     time.Now(),
+				}
 			}
 
 			state.CurrentState = state.EventState & ^scard.StateChanged
@@ -341,6 +351,7 @@ func (mon *scardMon) updateReadyReaders(newReadyReaders map[string]readyReaderIn
 				presence: Available,
 				scardCtx: mon.scardContext,
 				ctx:      ctx,
+				time:     time.Now(),
 			}
 		}
 	}
@@ -354,6 +365,8 @@ func (mon *scardMon) updateReadyReaders(newReadyReaders map[string]readyReaderIn
 				id:       s.reader,
 				presence: Unavailable,
 				scardCtx: mon.scardContext,
+				ctx:      mon.ctx,
+				time:     time.Now(),
 			}
 			s.cancel()
 		}
@@ -1,13 +1,33 @@
 package yubierror
 
+import "fmt"
+
 type YubiKeyError uint32
 
 const (
-	_                               = iota
-	ErrorChkWrong      YubiKeyError = iota
-	ErrorWrongPassword YubiKeyError = iota
-	ErrorUserCancled   YubiKeyError = iota
-	ErrorSlotNotFound  YubiKeyError = iota
+	_                                        = iota
+	ErrorChkWrong               YubiKeyError = iota
+	ErrorWrongPassword          YubiKeyError = iota
+	ErrorUserCancled            YubiKeyError = iota
+	ErrorSlotNotFound           YubiKeyError = iota
+	ErrorTouchTimeout           YubiKeyError = iota
+	ErrorAuthRequired           YubiKeyError = iota
+	ErrorNoSuchObject           YubiKeyError = iota
+	ErrorWrongData              YubiKeyError = iota
+	ErrorNoSpace                YubiKeyError = iota
+	ErrorInsNotSupported        YubiKeyError = iota
+	ErrorMemory                 YubiKeyError = iota
+	ErrorWrongLength            YubiKeyError = iota
+	ErrorConditionsNotSatisfied YubiKeyError = iota
+	ErrorWrongParameters        YubiKeyError = iota
+	ErrorClaNotSupported        YubiKeyError = iota
+	ErrorAppletNotFound         YubiKeyError = iota
+	ErrorUnexpectedStatus       YubiKeyError = iota
+	ErrorVerificationFailed     YubiKeyError = iota
+	ErrorMalformedResponse      YubiKeyError = iota
+	ErrorCardBusy               YubiKeyError = iota
+	ErrorCardReset              YubiKeyError = iota
+	ErrorCommandTooLong         YubiKeyError = iota
 )
 
 func (e YubiKeyError) Error() string {
@@ -20,6 +40,90 @@ func (e YubiKeyError) Error() string {
 		return "User canceled"
 	case ErrorSlotNotFound:
 		return "No slot with the specified name was found"
+	case ErrorTouchTimeout:
+		return "The YubiKey was not touched in time"
+	case ErrorAuthRequired:
+		return "The YubiKey requires authentication, check the password"
+	case ErrorNoSuchObject:
+		return "No such credential on the YubiKey"
+	case ErrorWrongData:
+		return "The YubiKey rejected the data sent to it"
+	case ErrorNoSpace:
+		return "No space left on the YubiKey"
+	case ErrorInsNotSupported:
+		return "The YubiKey does not support this operation, a newer firmware may be required"
+	case ErrorMemory:
+		return "Memory error on the YubiKey"
+	case ErrorWrongLength:
+		return "The YubiKey rejected the length of the command"
+	case ErrorConditionsNotSatisfied:
+		return "The YubiKey is not in a state to perform this operation"
+	case ErrorWrongParameters:
+		return "The YubiKey rejected the parameters of the command"
+	case ErrorClaNotSupported:
+		return "The YubiKey does not support the command class"
+	case ErrorAppletNotFound:
+		return "The application was not found on the YubiKey"
+	case ErrorUnexpectedStatus:
+		return "Unexpected response from the YubiKey"
+	case ErrorVerificationFailed:
+		return "Security warning: the YubiKey failed to prove knowledge of the password and may not be genuine"
+	case ErrorMalformedResponse:
+		return "Malformed response from the YubiKey"
+	case ErrorCardBusy:
+		return "The YubiKey is in use by another application"
+	case ErrorCardReset:
+		return "The YubiKey was reset by another application"
+	case ErrorCommandTooLong:
+		return "The command is too long for the YubiKey"
 	}
 	return "unknown error"
 }
+
+// StatusError is returned when the YubiKey answers with a status word other than 0x9000.
+// It unwraps to the YubiKeyError matching the status word, so it can be checked with errors.Is.
+type StatusError struct {
+	SW  uint16
+	Err YubiKeyError
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("%s (SW %04X)", e.Err.Error(), e.SW)
+}
+
+func (e StatusError) Unwrap() error {
+	return e.Err
+}
+
+// StatusErrorNew maps an ISO 7816 status word to a StatusError
+func StatusErrorNew(sw uint16) StatusError {
+	var err YubiKeyError
+	switch sw {
+	case 0x6581:
+		err = ErrorMemory
+	case 0x6700:
+		err = ErrorWrongLength
+	case 0x6982:
+		err = ErrorAuthRequired
+	case 0x6984:
+		err = ErrorNoSuchObject
+	case 0x6985:
+		err = ErrorConditionsNotSatisfied
+	case 0x6A80:
+		err = ErrorWrongData
+	case 0x6A82:
+		err = ErrorAppletNotFound
+	case 0x6A84:
+		err = ErrorNoSpace
+	case 0x6A86:
+		err = ErrorWrongParameters
+	case 0x6D00:
+		err = ErrorInsNotSupported
+	case 0x6E00:
+		err = ErrorClaNotSupported
+	default:
+		err = ErrorUnexpectedStatus
+	}
+
+	return StatusError{SW: sw, Err: err}
+}
@@ -0,0 +1,26 @@
+package yubierror
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusErrorNew(t *testing.T) {
+	err := error(StatusErrorNew(0x6A84))
+
+	assert.True(t, errors.Is(err, ErrorNoSpace))
+	assert.False(t, errors.Is(err, ErrorNoSuchObject))
+
+	var statusErr StatusError
+	assert.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, uint16(0x6A84), statusErr.SW)
+	assert.Equal(t, "No space left on the YubiKey (SW 6A84)", err.Error())
+}
+
+func TestStatusErrorNew_Unknown(t *testing.T) {
+	err := StatusErrorNew(0x6F00)
+
+	assert.True(t, errors.Is(err, ErrorUnexpectedStatus))
+}
@@ -0,0 +1,196 @@
+package yubikey
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/MeneDev/yubi-oath-vpn/oath"
+	"github.com/MeneDev/yubi-oath-vpn/yubierror"
+)
+
+// Version is a firmware version
+type Version [3]byte
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
+
+// Compare returns -1, 0 or 1 if v is older, equal or newer than other
+func (v Version) Compare(other Version) int {
+	for i := range v {
+		if v[i] < other[i] {
+			return -1
+		}
+		if v[i] > other[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// ParseVersion parses a version of the form major[.minor[.patch]], missing parts are 0
+func ParseVersion(str string) (Version, error) {
+	var v Version
+	parts := strings.Split(str, ".")
+	if len(parts) > 3 {
+		return v, fmt.Errorf("invalid version %q", str)
+	}
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 8)
+		if err != nil {
+			return v, fmt.Errorf("invalid version %q", str)
+		}
+		v[i] = byte(n)
+	}
+	return v, nil
+}
+
+type FormFactor byte
+
+const (
+	FormFactorUnknown       FormFactor = 0x00
+	FormFactorUsbAKeychain  FormFactor = 0x01
+	FormFactorUsbANano      FormFactor = 0x02
+	FormFactorUsbCKeychain  FormFactor = 0x03
+	FormFactorUsbCNano      FormFactor = 0x04
+	FormFactorUsbCLightning FormFactor = 0x05
+	FormFactorUsbABio       FormFactor = 0x06
+	FormFactorUsbCBio       FormFactor = 0x07
+)
+
+func (f FormFactor) String() string {
+	switch f {
+	case FormFactorUsbAKeychain:
+		return "USB-A Keychain"
+	case FormFactorUsbANano:
+		return "USB-A Nano"
+	case FormFactorUsbCKeychain:
+		return "USB-C Keychain"
+	case FormFactorUsbCNano:
+		return "USB-C Nano"
+	case FormFactorUsbCLightning:
+		return "USB-C Lightning"
+	case FormFactorUsbABio:
+		return "USB-A Bio"
+	case FormFactorUsbCBio:
+		return "USB-C Bio"
+	}
+	return "Unknown"
+}
+
+// Capability is a bit set of the applications of a YubiKey
+type Capability uint16
+
+const (
+	CapabilityOtp     Capability = 0x0001
+	CapabilityU2f     Capability = 0x0002
+	CapabilityOpenPgp Capability = 0x0008
+	CapabilityPiv     Capability = 0x0010
+	CapabilityOath    Capability = 0x0020
+	CapabilityHsmAuth Capability = 0x0100
+	CapabilityFido2   Capability = 0x0200
+)
+
+var capabilityNames = []struct {
+	capability Capability
+	name       string
+}{
+	{CapabilityOtp, "OTP"},
+	{CapabilityU2f, "U2F"},
+	{CapabilityOpenPgp, "OpenPGP"},
+	{CapabilityPiv, "PIV"},
+	{CapabilityOath, "OATH"},
+	{CapabilityHsmAuth, "HSMAUTH"},
+	{CapabilityFido2, "FIDO2"},
+}
+
+func (c Capability) String() string {
+	var names []string
+	for _, n := range capabilityNames {
+		if c&n.capability != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// DeviceInfo describes a YubiKey as reported by the management application
+type DeviceInfo struct {
+	Serial       uint32
+	Version      Version
+	FormFactor   FormFactor
+	IsFips       bool
+	IsSky        bool
+	UsbSupported Capability
+	UsbEnabled   Capability
+	NfcSupported Capability
+	NfcEnabled   Capability
+}
+
+func (info DeviceInfo) String() string {
+	return fmt.Sprintf("YubiKey %s, firmware %s, serial %d", info.FormFactor, info.Version, info.Serial)
+}
+
+const (
+	deviceInfoTagUsbSupported byte = 0x01
+	deviceInfoTagSerial       byte = 0x02
+	deviceInfoTagUsbEnabled   byte = 0x03
+	deviceInfoTagFormFactor   byte = 0x04
+	deviceInfoTagVersion      byte = 0x05
+	deviceInfoTagNfcSupported byte = 0x0d
+	deviceInfoTagNfcEnabled   byte = 0x0e
+)
+
+// ParseDeviceInfo decodes the response of the management application's read config command,
+// a length byte followed by TLVs
+func ParseDeviceInfo(data []byte) (DeviceInfo, error) {
+	var info DeviceInfo
+	if len(data) < 1 || int(data[0]) > len(data)-1 {
+		return info, fmt.Errorf("%w: device info truncated", yubierror.ErrorMalformedResponse)
+	}
+
+	tlvs, err := oath.ParseTlvs(data[1 : 1+int(data[0])])
+	if err != nil {
+		return info, err
+	}
+
+	for _, tlv := range tlvs {
+		switch tlv.Tag {
+		case deviceInfoTagSerial:
+			if len(tlv.Value) == 4 {
+				info.Serial = binary.BigEndian.Uint32(tlv.Value)
+			}
+		case deviceInfoTagVersion:
+			if len(tlv.Value) == 3 {
+				copy(info.Version[:], tlv.Value)
+			}
+		case deviceInfoTagFormFactor:
+			if len(tlv.Value) == 1 {
+				info.FormFactor = FormFactor(tlv.Value[0] & 0x0f)
+				info.IsFips = tlv.Value[0]&0x80 != 0
+				info.IsSky = tlv.Value[0]&0x40 != 0
+			}
+		case deviceInfoTagUsbSupported:
+			info.UsbSupported = parseCapability(tlv.Value)
+		case deviceInfoTagUsbEnabled:
+			info.UsbEnabled = parseCapability(tlv.Value)
+		case deviceInfoTagNfcSupported:
+			info.NfcSupported = parseCapability(tlv.Value)
+		case deviceInfoTagNfcEnabled:
+			info.NfcEnabled = parseCapability(tlv.Value)
+		}
+	}
+
+	return info, nil
+}
+
+// parseCapability decodes capabilities sent as one or two bytes
+func parseCapability(value []byte) Capability {
+	var c Capability
+	for _, b := range value {
+		c = c<<8 | Capability(b)
+	}
+	return c
+}
@@ -0,0 +1,82 @@
+package yubikey
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDeviceInfo(t *testing.T) {
+	t.Run("YubiKey 5 NFC", func(t *testing.T) {
+		info, err := ParseDeviceInfo([]byte{
+			0x1a,
+			0x01, 0x02, 0x02, 0x3b,
+			0x02, 0x04, 0x00, 0x9a, 0x9c, 0x6b,
+			0x03, 0x02, 0x02, 0x3b,
+			0x04, 0x01, 0x01,
+			0x05, 0x03, 0x05, 0x02, 0x04,
+			0x0d, 0x02, 0x02, 0x3b,
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, DeviceInfo{
+			Serial:       10132587,
+			Version:      Version{5, 2, 4},
+			FormFactor:   FormFactorUsbAKeychain,
+			UsbSupported: 0x023b,
+			UsbEnabled:   0x023b,
+			NfcSupported: 0x023b,
+		}, info)
+		assert.Equal(t, "OTP, U2F, OpenPGP, PIV, OATH, FIDO2", info.UsbEnabled.String())
+		assert.Equal(t, "YubiKey USB-A Keychain, firmware 5.2.4, serial 10132587", info.String())
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		_, err := ParseDeviceInfo([]byte{0x08, 0x02, 0x04, 0x00})
+
+		assert.Error(t, err)
+	})
+}
+
+func TestTransportYubiKey_DeviceInfo(t *testing.T) {
+	t.Run("reads the management application without the OTP applet", func(t *testing.T) {
+		transport := &traceTransport{t: t, trace: []traceExchange{
+			{ins: 0xa4, response: []byte{0x90, 0x00}},
+			{ins: 0x1d, response: []byte{0x0b, 0x02, 0x04, 0x00, 0x9a, 0x9c, 0x6b, 0x05, 0x03, 0x05, 0x02, 0x04, 0x90, 0x00}},
+		}}
+		key := YubiKeyNew(context.Background(), transport)
+
+		info, err := key.DeviceInfo()
+
+		assert.NoError(t, err)
+		assert.Equal(t, DeviceInfo{Serial: 10132587, Version: Version{5, 2, 4}}, info)
+	})
+
+	t.Run("falls back to the OTP applet on old firmware", func(t *testing.T) {
+		transport := &traceTransport{t: t, trace: []traceExchange{
+			{ins: 0xa4, response: []byte{0x90, 0x00}},
+			{ins: 0x1d, response: []byte{0x6d, 0x00}},
+			{ins: 0xa4, response: []byte{0x04, 0x00, 0x05, 0x01, 0x00, 0x00, 0x90, 0x00}},
+			{ins: 0x01, response: []byte{0x00, 0x9a, 0x9c, 0x6b, 0x90, 0x00}},
+		}}
+		key := YubiKeyNew(context.Background(), transport)
+
+		info, err := key.DeviceInfo()
+
+		assert.NoError(t, err)
+		assert.Equal(t, DeviceInfo{Serial: 10132587, Version: Version{4, 0, 5}}, info)
+	})
+}
+
+func TestParseVersion(t *testing.T) {
+	v, err := ParseVersion("5.2")
+	assert.NoError(t, err)
+	assert.Equal(t, Version{5, 2, 0}, v)
+	assert.Equal(t, -1, v.Compare(Version{5, 2, 4}))
+
+	for _, invalid := range []string{"", "5..1", "5.2.3.4", "five", "5.256"} {
+		_, err := ParseVersion(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
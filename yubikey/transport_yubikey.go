@@ -0,0 +1,520 @@
+package yubikey
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/MeneDev/yubi-oath-vpn/apdu"
+	"github.com/MeneDev/yubi-oath-vpn/oath"
+	"github.com/MeneDev/yubi-oath-vpn/yubierror"
+	"github.com/rs/zerolog/log"
+)
+
+var _ YubiKey = (*transportYubiKey)(nil)
+
+// a command sequence is tried transactionAttempts times, the delay between the attempts starts at transactionRetryDelay
+// and doubles with every attempt
+const transactionAttempts = 5
+
+var transactionRetryDelay = 100 * time.Millisecond
+
+type transportYubiKey struct {
+	ctx       context.Context
+	transport apdu.Transport
+	session   *oath.Session
+	// mu serializes the command sequences sent to the key
+	mu sync.Mutex
+
+	infoMu sync.Mutex
+	info   *DeviceInfo
+
+	// accessKey is derived from the password of the last successful validation, salt is the device id it belongs to.
+	// Both are dropped when the key is removed.
+	accessKey []byte
+	salt      []byte
+}
+
+// YubiKeyNew creates a YubiKey speaking to the OATH applet over the transport.
+// The key is considered removed when ctx is done, it keeps its device info and access key until then.
+func YubiKeyNew(ctx context.Context, transport apdu.Transport) YubiKey {
+	key := &transportYubiKey{ctx: ctx, transport: transport, session: oath.SessionNew(transport)}
+
+	if ctx.Done() != nil {
+		go func() {
+			<-ctx.Done()
+
+			key.mu.Lock()
+			defer key.mu.Unlock()
+			key.forgetAccessKey()
+		}()
+	}
+
+	return key
+}
+
+func (key *transportYubiKey) Context() context.Context {
+	return key.ctx
+}
+
+// DeviceInfo reads the device info once, it does not change while the key is inserted
+func (key *transportYubiKey) DeviceInfo() (DeviceInfo, error) {
+	key.infoMu.Lock()
+	defer key.infoMu.Unlock()
+
+	if key.info != nil {
+		return *key.info, nil
+	}
+
+	var info DeviceInfo
+	err := key.transaction(func() (err error) {
+		info, err = key.readDeviceInfo()
+		return err
+	})
+	if err != nil {
+		return info, err
+	}
+
+	key.info = &info
+	return info, nil
+}
+
+func (key *transportYubiKey) readDeviceInfo() (DeviceInfo, error) {
+	info, err := key.readManagementInfo()
+	if err == nil {
+		return info, nil
+	}
+
+	// firmware older than 4.1 does not support reading the device info
+	log.Debug().Err(err).Msg("device info not available, reading the serial from the OTP application")
+	return key.readOtpInfo()
+}
+
+func (key *transportYubiKey) readManagementInfo() (DeviceInfo, error) {
+	session := key.session
+
+	_, err := session.SelectAid(oath.AID_MGR)
+	if err != nil {
+		return DeviceInfo{}, err
+	}
+
+	rsp, err := session.Send(0, oath.MGR_INS_READ_CONFIG, 0, 0, []byte{})
+	if err != nil {
+		return DeviceInfo{}, err
+	}
+
+	info, err := ParseDeviceInfo(rsp)
+	if err != nil {
+		log.Warn().Err(err).Hex("value", rsp).Msg("cannot parse device info")
+		return DeviceInfo{}, err
+	}
+
+	return info, nil
+}
+
+// readOtpInfo reads the firmware version and serial from the OTP application
+func (key *transportYubiKey) readOtpInfo() (DeviceInfo, error) {
+	session := key.session
+
+	rsp, err := session.SelectAid(oath.AID_OTP)
+	if err != nil {
+		log.Error().Err(err).Msg("Error setting 'AID_OTP'")
+		return DeviceInfo{}, err
+	}
+
+	var info DeviceInfo
+	if len(rsp) >= 3 {
+		copy(info.Version[:], rsp[:3])
+	}
+
+	info.Serial, err = session.ReadSerial()
+	if err != nil {
+		log.Error().Err(err).Msg("Error reading serial")
+		return DeviceInfo{}, err
+	}
+
+	return info, nil
+}
+
+func (key *transportYubiKey) GetCodeWithPassword(pwd string, slotName string, clock oath.Clock, touchRequired func()) (oath.Code, error) {
+	// the device info is only logged, codes can be calculated without it
+	info, err := key.DeviceInfo()
+	if err != nil {
+		log.Warn().Err(err).Msg("cannot read device info")
+	} else {
+		log.Debug().
+			Uint32("serial", info.Serial).
+			Str("version", info.Version.String()).
+			Str("form_factor", info.FormFactor.String()).
+			Str("usb_enabled", info.UsbEnabled.String()).
+			Msg("device info")
+	}
+
+	var code oath.Code
+	err = key.transaction(func() (err error) {
+		code, err = key.calculateCode(pwd, slotName, clock, touchRequired)
+		return err
+	})
+	return code, err
+}
+
+func (key *transportYubiKey) calculateCode(pwd string, slotName string, clock oath.Clock, touchRequired func()) (oath.Code, error) {
+	session := key.session
+
+	_, err := key.unlock(pwd)
+	if err != nil {
+		return oath.Code{}, err
+	}
+
+	now := clock.Now()
+	results, err := session.CalculateAll(oath.TimeChallenge(now, oath.DefaultPeriod))
+	if err != nil {
+		return oath.Code{}, err
+	}
+
+	for _, result := range results {
+		if slotName != "" && result.Name != slotName {
+			log.Debug().Str("slot", result.Name).Msg("slot did not match")
+			continue
+		}
+		log.Debug().Str("slot", result.Name).Msg("slot matched")
+
+		period, _, _ := oath.ParseCredentialName(result.Name)
+		truncated := result.Truncated
+
+		hotp := result.Hotp
+		if result.RequiresTouch {
+			// CALCULATE_ALL reports touch-required credentials of both types with the touch tag, LIST reports the type
+			hotp, err = key.isHotp(result.Name)
+			if err != nil {
+				return oath.Code{}, err
+			}
+
+			// the key only calculates the code of touch-required credentials one at a time, blocking until touched
+			log.Info().Str("slot", result.Name).Msg("credential requires touch")
+			if touchRequired != nil {
+				touchRequired()
+			}
+		}
+
+		switch {
+		case hotp:
+			// HOTP credentials are skipped by CALCULATE_ALL so their counter does not advance by accident
+			log.Debug().Str("slot", result.Name).Msg("calculating HOTP code")
+			truncated, err = session.Calculate(result.Name, []byte{})
+		case result.RequiresTouch:
+			now = clock.Now()
+			truncated, err = session.Calculate(result.Name, oath.TimeChallenge(now, period))
+		case period != oath.DefaultPeriod:
+			// CALCULATE_ALL always uses the default period, credentials with a different period need their own challenge
+			log.Debug().Str("slot", result.Name).Int("period", period).Msg("calculating code with custom period")
+			truncated, err = session.Calculate(result.Name, oath.TimeChallenge(now, period))
+		}
+		if result.RequiresTouch && errors.Is(err, yubierror.ErrorAuthRequired) {
+			// security condition not satisfied right after the validation, the key was not touched in time
+			log.Warn().Str("slot", result.Name).Msg("key was not touched in time")
+			return oath.Code{}, yubierror.ErrorTouchTimeout
+		}
+		if err != nil {
+			return oath.Code{}, err
+		}
+
+		value, err := formatTruncated(truncated)
+		if err != nil {
+			return oath.Code{}, err
+		}
+
+		if hotp {
+			return oath.Code{Name: result.Name, Value: value}, nil
+		}
+		return oath.TotpCode(result.Name, value, now, period), nil
+	}
+
+	return oath.Code{}, yubierror.ErrorSlotNotFound
+}
+
+func (key *transportYubiKey) isHotp(name string) (bool, error) {
+	creds, err := key.session.List()
+	if err != nil {
+		return false, err
+	}
+
+	for _, cred := range creds {
+		if cred.Name == name {
+			return cred.Type == oath.HOTP, nil
+		}
+	}
+	return false, yubierror.ErrorSlotNotFound
+}
+
+// RequiresPassword is false when the OATH applet is not protected by a password or was unlocked before
+func (key *transportYubiKey) RequiresPassword() (bool, error) {
+	requiresPassword := true
+	err := key.transaction(func() error {
+		selected, err := key.session.Select()
+		if err != nil {
+			return err
+		}
+
+		// the key only sends a challenge when the OATH applet is protected by a password
+		if !selected.RequiresPassword() {
+			requiresPassword = false
+			return nil
+		}
+
+		err = key.validateAccessKey(selected)
+		if retryable(err) {
+			return err
+		}
+
+		requiresPassword = err != nil
+		return nil
+	})
+
+	return requiresPassword, err
+}
+
+// unlock selects the OATH applet and validates the password, an empty password validates with the access key of
+// a previous validation
+func (key *transportYubiKey) unlock(pwd string) (oath.SelectResponse, error) {
+	selected, err := key.session.Select()
+	if err != nil || !selected.RequiresPassword() {
+		return selected, err
+	}
+
+	if pwd == "" {
+		err = key.validateAccessKey(selected)
+		if !errors.Is(err, yubierror.ErrorWrongPassword) {
+			return selected, err
+		}
+	}
+
+	accessKey := oath.DeriveKey(pwd, selected.Salt)
+	err = key.session.ValidateKey(accessKey, selected)
+	if err != nil {
+		return selected, err
+	}
+
+	key.accessKey = accessKey
+	key.salt = selected.Salt
+	return selected, nil
+}
+
+// validateAccessKey validates with the access key of a previous validation, the key is dropped if it does not work
+// anymore, e.g. because the password was changed or the applet was reset
+func (key *transportYubiKey) validateAccessKey(selected oath.SelectResponse) error {
+	if key.accessKey == nil || !bytes.Equal(key.salt, selected.Salt) {
+		key.forgetAccessKey()
+		return yubierror.ErrorWrongPassword
+	}
+
+	err := key.session.ValidateKey(key.accessKey, selected)
+	if errors.Is(err, yubierror.ErrorWrongPassword) {
+		log.Info().Msg("stored access key was rejected, the password is required again")
+		key.forgetAccessKey()
+	}
+	return err
+}
+
+func (key *transportYubiKey) DeviceId() ([]byte, error) {
+	var deviceId []byte
+	err := key.transaction(func() error {
+		selected, err := key.session.Select()
+		deviceId = selected.Salt
+		return err
+	})
+
+	return deviceId, err
+}
+
+func (key *transportYubiKey) AccessKey() []byte {
+	key.mu.Lock()
+	defer key.mu.Unlock()
+
+	return append([]byte(nil), key.accessKey...)
+}
+
+func (key *transportYubiKey) UnlockWithAccessKey(accessKey []byte) error {
+	return key.transaction(func() error {
+		selected, err := key.session.Select()
+		if err != nil || !selected.RequiresPassword() {
+			return err
+		}
+
+		err = key.session.ValidateKey(accessKey, selected)
+		if err != nil {
+			return err
+		}
+
+		key.accessKey = accessKey
+		key.salt = selected.Salt
+		return nil
+	})
+}
+
+func (key *transportYubiKey) forgetAccessKey() {
+	key.accessKey = nil
+	key.salt = nil
+}
+
+func (key *transportYubiKey) SetPassword(pwd string, newPwd string) error {
+	return key.transaction(func() error {
+		selected, err := key.unlock(pwd)
+		if err != nil {
+			return err
+		}
+
+		err = key.session.SetCode(newPwd, selected)
+		if err != nil {
+			log.Error().Err(err).Msg("error setting password")
+			return err
+		}
+
+		key.forgetAccessKey()
+
+		log.Info().Bool("removed", newPwd == "").Msg("password changed")
+		return nil
+	})
+}
+
+func (key *transportYubiKey) ListCredentials(pwd string) ([]oath.Credential, error) {
+	var creds []oath.Credential
+	err := key.transaction(func() (err error) {
+		creds, err = key.listCredentials(pwd)
+		return err
+	})
+
+	return creds, err
+}
+
+func (key *transportYubiKey) listCredentials(pwd string) ([]oath.Credential, error) {
+	_, err := key.unlock(pwd)
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := key.session.List()
+	if err != nil {
+		return nil, err
+	}
+
+	// LIST does not report the touch requirement, CALCULATE_ALL marks those credentials
+	results, err := key.session.CalculateAll(oath.TimeChallenge(time.Now(), oath.DefaultPeriod))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, result := range results {
+		if !result.RequiresTouch {
+			continue
+		}
+		for i := range creds {
+			if creds[i].Name == result.Name {
+				creds[i].RequiresTouch = true
+			}
+		}
+	}
+
+	return creds, nil
+}
+
+func (key *transportYubiKey) PutCredential(pwd string, credential oath.CredentialData) error {
+	return key.transaction(func() error {
+		_, err := key.unlock(pwd)
+		if err != nil {
+			return err
+		}
+
+		err = key.session.Put(credential)
+		if err != nil {
+			log.Error().Err(err).Str("slot", credential.Name()).Msg("error adding credential")
+			return err
+		}
+
+		return nil
+	})
+}
+
+func (key *transportYubiKey) DeleteCredential(pwd string, name string) error {
+	return key.transaction(func() error {
+		_, err := key.unlock(pwd)
+		if err != nil {
+			return err
+		}
+
+		err = key.session.Delete(name)
+		if err != nil {
+			log.Error().Err(err).Str("slot", name).Msg("error deleting credential")
+			return err
+		}
+
+		return nil
+	})
+}
+
+func (key *transportYubiKey) RenameCredential(pwd string, name string, newName string) error {
+	return key.transaction(func() error {
+		_, err := key.unlock(pwd)
+		if err != nil {
+			return err
+		}
+
+		err = key.session.Rename(name, newName)
+		if err != nil {
+			log.Error().Err(err).Str("slot", name).Str("new_slot", newName).Msg("error renaming credential")
+			return err
+		}
+
+		return nil
+	})
+}
+
+// transaction runs a command sequence with exclusive access to the key, so other applications cannot select another
+// applet in between. The sequence is retried with increasing delays while the key is used by another application
+// and starts over when the key was reset, because the selected applet and the authentication are lost then.
+func (key *transportYubiKey) transaction(sequence func() error) error {
+	key.mu.Lock()
+	defer key.mu.Unlock()
+
+	delay := transactionRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := key.transport.BeginTransaction()
+		if err == nil {
+			err = sequence()
+
+			endErr := key.transport.EndTransaction()
+			if endErr != nil {
+				log.Debug().Err(endErr).Msg("cannot end transaction")
+			}
+		}
+
+		if !retryable(err) || attempt >= transactionAttempts {
+			return err
+		}
+
+		log.Info().Err(err).Int("attempt", attempt).Dur("delay", delay).Msg("retrying YubiKey operation")
+		select {
+		case <-key.ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func retryable(err error) bool {
+	return errors.Is(err, yubierror.ErrorCardBusy) || errors.Is(err, yubierror.ErrorCardReset)
+}
+
+func formatTruncated(value []byte) (string, error) {
+	code, err := oath.FormatCode(value)
+	if err != nil {
+		log.Error().Err(err).Hex("raw_code", value).Msg("malformed code message received")
+		return "", err
+	}
+	log.Debug().Hex("raw_code", value).Msg("code message received")
+
+	return code, nil
+}
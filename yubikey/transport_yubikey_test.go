@@ -0,0 +1,162 @@
+package yubikey
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MeneDev/yubi-oath-vpn/apdu"
+	"github.com/MeneDev/yubi-oath-vpn/oath"
+	"github.com/MeneDev/yubi-oath-vpn/yubierror"
+	"github.com/stretchr/testify/assert"
+)
+
+type traceExchange struct {
+	ins      byte
+	response []byte
+}
+
+var _ apdu.Transport = (*traceTransport)(nil)
+
+// shortenRetryDelay speeds up the retries of busy transactions for the duration of the test
+func shortenRetryDelay(t *testing.T) {
+	delay := transactionRetryDelay
+	t.Cleanup(func() { transactionRetryDelay = delay })
+	transactionRetryDelay = time.Millisecond
+}
+
+// traceTransport replays recorded responses, commands are only checked by their instruction byte
+type traceTransport struct {
+	t     *testing.T
+	trace []traceExchange
+	// busy is the number of transactions that fail because another application uses the card
+	busy int
+}
+
+func (tr *traceTransport) Transmit(command []byte) ([]byte, error) {
+	if !assert.NotEmpty(tr.t, tr.trace, "unexpected command % x", command) {
+		return []byte{0x6F, 0x00}, nil
+	}
+
+	exchange := tr.trace[0]
+	tr.trace = tr.trace[1:]
+	assert.Equal(tr.t, exchange.ins, command[1], "unexpected command % x", command)
+
+	return exchange.response, nil
+}
+
+func (tr *traceTransport) BeginTransaction() error {
+	if tr.busy > 0 {
+		tr.busy--
+		return yubierror.ErrorCardBusy
+	}
+	return nil
+}
+
+func (tr *traceTransport) EndTransaction() error { return nil }
+func (tr *traceTransport) Reconnect() error      { return nil }
+
+var selectWithoutPassword = []byte{
+	0x79, 0x03, 0x05, 0x02, 0x04,
+	0x71, 0x08, 0x5b, 0x1c, 0xcc, 0x20, 0xd4, 0xab, 0x2f, 0xdf,
+	0x90, 0x00,
+}
+
+var selectWithPassword = []byte{
+	0x79, 0x03, 0x05, 0x02, 0x04,
+	0x71, 0x08, 0x5b, 0x1c, 0xcc, 0x20, 0xd4, 0xab, 0x2f, 0xdf,
+	0x74, 0x08, 0xD3, 0x47, 0x6C, 0xC6, 0x00, 0x52, 0x4A, 0x5C,
+	0x7b, 0x01, 0x01,
+	0x90, 0x00,
+}
+
+func TestTransportYubiKey_RequiresPassword(t *testing.T) {
+	t.Run("without challenge", func(t *testing.T) {
+		transport := &traceTransport{t: t, trace: []traceExchange{{ins: 0xa4, response: selectWithoutPassword}}}
+		key := YubiKeyNew(context.Background(), transport)
+
+		required, err := key.RequiresPassword()
+
+		assert.NoError(t, err)
+		assert.False(t, required)
+	})
+
+	t.Run("retries while the card is busy", func(t *testing.T) {
+		shortenRetryDelay(t)
+		transport := &traceTransport{t: t, busy: 2, trace: []traceExchange{{ins: 0xa4, response: selectWithoutPassword}}}
+		key := YubiKeyNew(context.Background(), transport)
+
+		required, err := key.RequiresPassword()
+
+		assert.NoError(t, err)
+		assert.False(t, required)
+		assert.Empty(t, transport.trace)
+	})
+
+	t.Run("gives up when the card stays busy", func(t *testing.T) {
+		shortenRetryDelay(t)
+		transport := &traceTransport{t: t, busy: transactionAttempts}
+		key := YubiKeyNew(context.Background(), transport)
+
+		_, err := key.RequiresPassword()
+
+		assert.Equal(t, yubierror.ErrorCardBusy, err)
+	})
+
+	t.Run("with challenge", func(t *testing.T) {
+		transport := &traceTransport{t: t, trace: []traceExchange{{ins: 0xa4, response: selectWithPassword}}}
+		key := YubiKeyNew(context.Background(), transport)
+
+		required, err := key.RequiresPassword()
+
+		assert.NoError(t, err)
+		assert.True(t, required)
+	})
+}
+
+func TestTransportYubiKey_ListCredentials(t *testing.T) {
+	transport := &traceTransport{t: t, trace: []traceExchange{
+		{ins: 0xa4, response: selectWithoutPassword},
+		// LIST split over two responses
+		{ins: 0xa1, response: []byte{0x72, 0x06, 0x21, 'a', ':', 'b', 'o', 'b', 0x61, 0x09}},
+		{ins: 0xa5, response: []byte{0x72, 0x07, 0x12, 'a', 'l', 'i', 'c', 'e', '2', 0x90, 0x00}},
+		{ins: 0xa4, response: []byte{
+			0x71, 0x05, 'a', ':', 'b', 'o', 'b', 0x7c, 0x01, 0x06,
+			0x71, 0x06, 'a', 'l', 'i', 'c', 'e', '2', 0x77, 0x01, 0x06,
+			0x90, 0x00,
+		}},
+	}}
+	key := YubiKeyNew(context.Background(), transport)
+
+	creds, err := key.ListCredentials("")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []oath.Credential{
+		{Name: "a:bob", Issuer: "a", Account: "bob", Type: oath.TOTP, Algorithm: oath.SHA1, Period: 30, RequiresTouch: true},
+		{Name: "alice2", Account: "alice2", Type: oath.HOTP, Algorithm: oath.SHA256},
+	}, creds)
+	assert.Empty(t, transport.trace)
+}
+
+func TestTransportYubiKey_GetCodeWithPassword(t *testing.T) {
+	t.Run("authentication required is not a touch timeout", func(t *testing.T) {
+		transport := &traceTransport{t: t, trace: []traceExchange{
+			// no device info
+			{ins: 0xa4, response: []byte{0x6a, 0x82}},
+			{ins: 0xa4, response: []byte{0x6a, 0x82}},
+			{ins: 0xa4, response: selectWithoutPassword},
+			{ins: 0xa4, response: []byte{
+				0x71, 0x04, '6', '0', '/', 'x', 0x76, 0x05, 0x06, 0x00, 0x00, 0x00, 0x01,
+				0x90, 0x00,
+			}},
+			{ins: 0xa2, response: []byte{0x69, 0x82}},
+		}}
+		key := YubiKeyNew(context.Background(), transport)
+
+		_, err := key.GetCodeWithPassword("", "60/x", oath.SystemClock{}, func() {})
+
+		assert.True(t, errors.Is(err, yubierror.ErrorAuthRequired))
+		assert.Empty(t, transport.trace)
+	})
+}
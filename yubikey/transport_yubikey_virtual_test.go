@@ -0,0 +1,197 @@
+package yubikey_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MeneDev/yubi-oath-vpn/oath"
+	"github.com/MeneDev/yubi-oath-vpn/oath/virtual"
+	"github.com/MeneDev/yubi-oath-vpn/yubierror"
+	"github.com/MeneDev/yubi-oath-vpn/yubikey"
+	"github.com/stretchr/testify/assert"
+)
+
+// RFC 4226 test secret
+var rfc4226Secret = []byte("12345678901234567890")
+
+var deviceIdSalt = []byte{0x5b, 0x1c, 0xcc, 0x20, 0xd4, 0xab, 0x2f, 0xdf}
+
+func TestTransportYubiKey_VirtualApplet(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("hotp without password", func(t *testing.T) {
+		applet := virtual.AppletNew(virtual.Config{
+			Version: [3]byte{5, 2, 4},
+			Credentials: []virtual.Credential{
+				{Name: "hotp", Type: oath.HOTP, Algorithm: oath.SHA1, Digits: 6, Secret: rfc4226Secret},
+			},
+		})
+		key := yubikey.YubiKeyNew(ctx, applet)
+
+		requiresPassword, err := key.RequiresPassword()
+		assert.NoError(t, err)
+		assert.False(t, requiresPassword)
+
+		code, err := key.GetCodeWithPassword("", "hotp", oath.SystemClock{}, func() {})
+		assert.NoError(t, err)
+		assert.Equal(t, "755224", code.Value)
+		assert.False(t, code.Expires())
+
+		code, err = key.GetCodeWithPassword("", "hotp", oath.SystemClock{}, func() {})
+		assert.NoError(t, err)
+		assert.Equal(t, "287082", code.Value)
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		applet := virtual.AppletNew(virtual.Config{Version: [3]byte{5, 2, 4}, Password: "abc"})
+		key := yubikey.YubiKeyNew(ctx, applet)
+
+		_, err := key.ListCredentials("wrong")
+		assert.Equal(t, yubierror.ErrorWrongPassword, err)
+	})
+
+	t.Run("manage credentials with password", func(t *testing.T) {
+		applet := virtual.AppletNew(virtual.Config{Version: [3]byte{5, 4, 3}, Password: "abc", MaxResponseLength: 16})
+		key := yubikey.YubiKeyNew(ctx, applet)
+
+		err := key.PutCredential("abc", oath.CredentialData{Issuer: "Example", Account: "alice", Type: oath.TOTP, Algorithm: oath.SHA256, Digits: 8, Period: 30, Secret: rfc4226Secret, RequiresTouch: true})
+		assert.NoError(t, err)
+		err = key.PutCredential("abc", oath.CredentialData{Account: "bob", Type: oath.HOTP, Algorithm: oath.SHA1, Digits: 6, Secret: rfc4226Secret, Counter: 1})
+		assert.NoError(t, err)
+
+		err = key.RenameCredential("abc", "bob", "carol")
+		assert.NoError(t, err)
+
+		creds, err := key.ListCredentials("abc")
+		assert.NoError(t, err)
+		assert.Equal(t, []oath.Credential{
+			{Name: "Example:alice", Issuer: "Example", Account: "alice", Type: oath.TOTP, Algorithm: oath.SHA256, Period: 30, RequiresTouch: true},
+			{Name: "carol", Account: "carol", Type: oath.HOTP, Algorithm: oath.SHA1},
+		}, creds)
+
+		code, err := key.GetCodeWithPassword("abc", "carol", oath.SystemClock{}, func() {})
+		assert.NoError(t, err)
+		assert.Equal(t, "287082", code.Value)
+
+		err = key.DeleteCredential("abc", "carol")
+		assert.NoError(t, err)
+		assert.Len(t, applet.Credentials(), 1)
+
+		err = key.SetPassword("abc", "")
+		assert.NoError(t, err)
+
+		requiresPassword, err := key.RequiresPassword()
+		assert.NoError(t, err)
+		assert.False(t, requiresPassword)
+	})
+
+	t.Run("stays unlocked while inserted", func(t *testing.T) {
+		applet := virtual.AppletNew(virtual.Config{
+			Version:  [3]byte{5, 2, 4},
+			Password: "abc",
+			Credentials: []virtual.Credential{
+				{Name: "hotp", Type: oath.HOTP, Algorithm: oath.SHA1, Digits: 6, Secret: rfc4226Secret},
+			},
+		})
+		ctx, cancel := context.WithCancel(ctx)
+		key := yubikey.YubiKeyNew(ctx, applet)
+
+		_, err := key.GetCodeWithPassword("wrong", "hotp", oath.SystemClock{}, func() {})
+		assert.Equal(t, yubierror.ErrorWrongPassword, err)
+		requiresPassword, _ := key.RequiresPassword()
+		assert.True(t, requiresPassword)
+
+		code, err := key.GetCodeWithPassword("abc", "hotp", oath.SystemClock{}, func() {})
+		assert.NoError(t, err)
+		assert.Equal(t, "755224", code.Value)
+
+		requiresPassword, _ = key.RequiresPassword()
+		assert.False(t, requiresPassword)
+		code, err = key.GetCodeWithPassword("", "hotp", oath.SystemClock{}, func() {})
+		assert.NoError(t, err)
+		assert.Equal(t, "287082", code.Value)
+
+		cancel()
+		assert.Eventually(t, func() bool {
+			requiresPassword, _ := key.RequiresPassword()
+			return requiresPassword
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("unlock with access key", func(t *testing.T) {
+		applet := virtual.AppletNew(virtual.Config{Version: [3]byte{5, 2, 4}, Password: "abc", DeviceId: deviceIdSalt})
+		key := yubikey.YubiKeyNew(ctx, applet)
+
+		deviceId, err := key.DeviceId()
+		assert.NoError(t, err)
+		assert.Equal(t, deviceIdSalt, deviceId)
+		assert.Nil(t, key.AccessKey())
+
+		err = key.UnlockWithAccessKey(oath.DeriveKey("wrong", deviceId))
+		assert.Equal(t, yubierror.ErrorWrongPassword, err)
+
+		err = key.UnlockWithAccessKey(oath.DeriveKey("abc", deviceId))
+		assert.NoError(t, err)
+		assert.Equal(t, oath.DeriveKey("abc", deviceId), key.AccessKey())
+
+		requiresPassword, _ := key.RequiresPassword()
+		assert.False(t, requiresPassword)
+	})
+
+	t.Run("totp at the time of the clock", func(t *testing.T) {
+		applet := virtual.AppletNew(virtual.Config{
+			Version: [3]byte{5, 2, 4},
+			Credentials: []virtual.Credential{
+				{Name: "totp", Type: oath.TOTP, Algorithm: oath.SHA1, Digits: 6, Secret: rfc4226Secret},
+			},
+		})
+		key := yubikey.YubiKeyNew(ctx, applet)
+		clock := oath.OffsetClockNew(oath.FixedClock{Time: time.Unix(29, 0)}, 30*time.Second)
+
+		// RFC 6238 test vector for T = 59
+		code, err := key.GetCodeWithPassword("", "totp", clock, func() {})
+		assert.NoError(t, err)
+		assert.Equal(t, "287082", code.Value)
+		assert.Equal(t, uint64(1), code.Step)
+		assert.Equal(t, time.Second, code.Remaining(clock.Now()))
+	})
+
+	t.Run("touch", func(t *testing.T) {
+		touched := false
+		applet := virtual.AppletNew(virtual.Config{
+			Version: [3]byte{5, 2, 4},
+			Credentials: []virtual.Credential{
+				{Name: "touch", Type: oath.TOTP, Algorithm: oath.SHA1, Digits: 6, Secret: rfc4226Secret, RequiresTouch: true},
+			},
+			Touch: func(name string) bool { return touched },
+		})
+		key := yubikey.YubiKeyNew(ctx, applet)
+
+		_, err := key.GetCodeWithPassword("", "touch", oath.SystemClock{}, func() {})
+		assert.Equal(t, yubierror.ErrorTouchTimeout, err)
+
+		code, err := key.GetCodeWithPassword("", "touch", oath.SystemClock{}, func() { touched = true })
+		assert.NoError(t, err)
+		assert.Len(t, code.Value, 6)
+		assert.True(t, code.Expires())
+	})
+
+	t.Run("hotp with touch", func(t *testing.T) {
+		touched := false
+		applet := virtual.AppletNew(virtual.Config{
+			Version: [3]byte{5, 2, 4},
+			Credentials: []virtual.Credential{
+				{Name: "hotp", Type: oath.HOTP, Algorithm: oath.SHA1, Digits: 6, Secret: rfc4226Secret, RequiresTouch: true},
+			},
+			Touch: func(name string) bool { return touched },
+		})
+		key := yubikey.YubiKeyNew(ctx, applet)
+
+		code, err := key.GetCodeWithPassword("", "hotp", oath.SystemClock{}, func() { touched = true })
+		assert.NoError(t, err)
+		assert.True(t, touched)
+		assert.Equal(t, "755224", code.Value)
+		assert.False(t, code.Expires())
+	})
+}
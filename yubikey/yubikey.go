@@ -1,8 +1,32 @@
 package yubikey
 
-import "context"
+import (
+	"context"
+
+	"github.com/MeneDev/yubi-oath-vpn/oath"
+)
 
 type YubiKey interface {
 	Context() context.Context
-	GetCodeWithPassword(password string, slotName string) (string, error)
+	// DeviceInfo reads serial, firmware version, form factor and capabilities of the key
+	DeviceInfo() (DeviceInfo, error)
+	// RequiresPassword is false when the key is not protected by a password or was unlocked before while inserted
+	RequiresPassword() (bool, error)
+	// SetPassword sets, changes or (with an empty newPassword) removes the password of the OATH applet
+	SetPassword(password string, newPassword string) error
+	// GetCodeWithPassword calculates the code of the slot for the time of the clock, touchRequired is called before waiting
+	// for the user to touch the key. The code reports the time span it is valid for.
+	// An empty password uses the access key of a previous successful call.
+	GetCodeWithPassword(password string, slotName string, clock oath.Clock, touchRequired func()) (oath.Code, error)
+	// DeviceId is the id of the OATH applet and the salt of its access key, it changes when the applet is reset
+	DeviceId() ([]byte, error)
+	// AccessKey returns the access key derived from the password of the last successful validation, nil if there is none
+	AccessKey() []byte
+	// UnlockWithAccessKey validates with an access key instead of the password, later calls can use an empty password
+	UnlockWithAccessKey(accessKey []byte) error
+	ListCredentials(password string) ([]oath.Credential, error)
+	PutCredential(password string, credential oath.CredentialData) error
+	DeleteCredential(password string, name string) error
+	// RenameCredential requires firmware 5.3 or newer
+	RenameCredential(password string, name string, newName string) error
 }
@@ -2,6 +2,8 @@ package yubimonitor
 
 import (
 	"context"
+	"sync"
+	"time"
 
 	"github.com/MeneDev/yubi-oath-vpn/scardmonitor"
 	"github.com/MeneDev/yubi-oath-vpn/yubikey"
@@ -15,12 +17,21 @@ type InsertionEvent interface {
 	Open() (yubikey.YubiKey, error)
 }
 
+// RemovalEvent reports a removed key, the context of a YubiKey opened for the reader is done as well
+type RemovalEvent interface {
+	Id() string
+	// Serial is the serial of the key opened for the reader, 0 if no key was opened or the serial could not be read
+	Serial() uint32
+	Time() time.Time
+}
+
 var _ InsertionEvent = (*scardYubiMonitorInsertedEvent)(nil)
 
 type scardYubiMonitorInsertedEvent struct {
 	ctx      context.Context
 	scardCtx *scard.Context
 	id       string
+	monitor  *yubiMonitor
 }
 
 func (s scardYubiMonitorInsertedEvent) Id() string {
@@ -33,26 +44,63 @@ func (s scardYubiMonitorInsertedEvent) Open() (yubikey.YubiKey, error) {
 	if err != nil {
 		log.Error().Err(err).Str("device", s.id).Msg("Error creating yubikey.YubiKey for device")
 	}
-	return scardyubi.YubiKeyNew(s.ctx, scardCtx, s.id)
+	key, err := scardyubi.YubiKeyNew(s.ctx, scardCtx, s.id)
+	if err != nil {
+		return key, err
+	}
+
+	// the serial cannot be read anymore when the key is removed
+	info, err := key.DeviceInfo()
+	if err != nil {
+		log.Debug().Err(err).Str("device", s.id).Msg("cannot read serial for removal events")
+	} else {
+		s.monitor.setSerial(s.id, info.Serial)
+	}
+
+	return key, nil
+}
+
+var _ RemovalEvent = (*yubiMonitorRemovedEvent)(nil)
+
+type yubiMonitorRemovedEvent struct {
+	id     string
+	serial uint32
+	time   time.Time
+}
+
+func (r yubiMonitorRemovedEvent) Id() string {
+	return r.id
+}
+
+func (r yubiMonitorRemovedEvent) Serial() uint32 {
+	return r.serial
+}
+
+func (r yubiMonitorRemovedEvent) Time() time.Time {
+	return r.time
 }
 
 type YubiMonitor interface {
 	InsertionChannel() <-chan InsertionEvent
+	// RemovalChannel must be consumed along with the InsertionChannel
+	RemovalChannel() <-chan RemovalEvent
 }
 
 func YubiMonitorNew(ctx context.Context) (YubiMonitor, error) {
 	ctx, cancel := context.WithCancel(ctx)
-	yubiMon := &yubiMonitor{ctx: ctx, cancel: cancel}
+	yubiMon := &yubiMonitor{ctx: ctx, cancel: cancel, serials: make(map[string]uint32)}
 
 	scardMon, _ := scardmonitor.ScardMonNew(ctx)
 	scardStatusChan := scardMon.StatusChannel()
 
 	yubiMon.insertedEvent = make(chan InsertionEvent)
+	yubiMon.removedEvent = make(chan RemovalEvent)
 	go func() {
 		defer func() {
 			log.Info().Msg("Stopping YubiMonitor")
 			cancel()
 			close(yubiMon.insertedEvent)
+			close(yubiMon.removedEvent)
 		}()
 
 		for {
@@ -61,9 +109,7 @@ func YubiMonitorNew(ctx context.Context) (YubiMonitor, error) {
 				return
 			case s := <-scardStatusChan:
 				log.Debug().Str("status", s.Id()).Msg("Received SCard status")
-				if s.Presence() == scardmonitor.Available {
-					yubiMon.insertedEvent <- scardYubiMonitorInsertedEvent{ctx: s.Context(), scardCtx: s.ScardContext(), id: s.Id()}
-				}
+				yubiMon.handleScardEvent(s)
 			}
 		}
 	}()
@@ -77,14 +123,51 @@ type yubiMonitor struct {
 	ctx           context.Context
 	cancel        context.CancelFunc
 	insertedEvent chan InsertionEvent
+	removedEvent  chan RemovalEvent
+
+	serialsMu sync.Mutex
+	// serials of the opened keys by reader
+	serials map[string]uint32
 }
 
-func (y yubiMonitor) InsertionChannel() <-chan InsertionEvent {
+func (y *yubiMonitor) InsertionChannel() <-chan InsertionEvent {
 	return y.insertedEvent
 }
 
-func (monitor yubiMonitor) handleScardEvent(event scardmonitor.ScardChangeEvent) {
-	if event.Presence() == scardmonitor.Available {
+func (y *yubiMonitor) RemovalChannel() <-chan RemovalEvent {
+	return y.removedEvent
+}
+
+func (y *yubiMonitor) setSerial(id string, serial uint32) {
+	y.serialsMu.Lock()
+	defer y.serialsMu.Unlock()
 
+	y.serials[id] = serial
+}
+
+func (y *yubiMonitor) takeSerial(id string) uint32 {
+	y.serialsMu.Lock()
+	defer y.serialsMu.Unlock()
+
+	serial := y.serials[id]
+	delete(y.serials, id)
+	return serial
+}
+
+func (y *yubiMonitor) handleScardEvent(event scardmonitor.ScardChangeEvent) {
+	switch event.Presence() {
+	case scardmonitor.Available:
+		inserted := scardYubiMonitorInsertedEvent{ctx: event.Context(), scardCtx: event.ScardContext(), id: event.Id(), monitor: y}
+		select {
+		case y.insertedEvent <- inserted:
+		case <-y.ctx.Done():
+		}
+	case scardmonitor.Unavailable:
+		removed := yubiMonitorRemovedEvent{id: event.Id(), serial: y.takeSerial(event.Id()), time: event.Time()}
+		log.Debug().Str("reader", removed.id).Uint32("serial", removed.serial).Msg("sending removal event")
+		select {
+		case y.removedEvent <- removed:
+		case <-y.ctx.Done():
+		}
 	}
 }